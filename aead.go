@@ -0,0 +1,90 @@
+// MIT License
+
+// Copyright (c) 2020 Tree Xie
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+)
+
+var (
+	// ErrKeyRequired at least one key is required for encryption
+	ErrKeyRequired = createError("encryption key is required")
+	// ErrKeyInvalid the key of AES should be 16, 24 or 32 bytes
+	ErrKeyInvalid = createError("encryption key should be 16, 24 or 32 bytes")
+	// ErrDecrypt the data can't be decrypted by any of the keys
+	ErrDecrypt = createError("decrypt session data fail")
+)
+
+// keyring the AES-GCM ciphers of keys, the first key is used for encryption
+// and all keys are tried for decryption, so the key can be rotated
+// by prepending the new key
+type keyring []cipher.AEAD
+
+func newKeyring(keys ...[]byte) (keyring, error) {
+	if len(keys) == 0 {
+		return nil, ErrKeyRequired
+	}
+	ring := make(keyring, 0, len(keys))
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, ErrKeyInvalid
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		ring = append(ring, aead)
+	}
+	return ring, nil
+}
+
+// seal encrypts the data with the first key, the result is nonce + ciphertext.
+// The additional data is authenticated but not encrypted,
+// it binds the ciphertext to its owner(such as session id)
+func (ring keyring) seal(data, additionalData []byte) ([]byte, error) {
+	aead := ring[0]
+	nonceSize := aead.NonceSize()
+	buf := make([]byte, nonceSize, nonceSize+len(data)+aead.Overhead())
+	_, err := rand.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(buf, buf, data, additionalData), nil
+}
+
+// open decrypts the data, all keys are tried in order
+func (ring keyring) open(data, additionalData []byte) ([]byte, error) {
+	for _, aead := range ring {
+		nonceSize := aead.NonceSize()
+		if len(data) < nonceSize+aead.Overhead() {
+			return nil, ErrDecrypt
+		}
+		buf, err := aead.Open(nil, data[:nonceSize], data[nonceSize:], additionalData)
+		if err == nil {
+			return buf, nil
+		}
+	}
+	return nil, ErrDecrypt
+}
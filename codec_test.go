@@ -0,0 +1,179 @@
+// MIT License
+
+// Copyright (c) 2020 Tree Xie
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodec(t *testing.T) {
+	now := time.Unix(1600000000, 123).UTC()
+	tests := []struct {
+		name  string
+		codec Codec
+	}{
+		{
+			name:  "json",
+			codec: JSONCodec,
+		},
+		{
+			name:  "gob",
+			codec: GobCodec,
+		},
+		{
+			name:  "msgpack",
+			codec: MsgpackCodec,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			m := M{
+				"a": "1",
+				"b": 2,
+				"c": true,
+				"d": []interface{}{"1", "2"},
+				"e": map[string]interface{}{
+					"f": "g",
+				},
+			}
+			if tt.codec != JSONCodec {
+				m["t"] = now
+			}
+			buf, err := tt.codec.Marshal(m)
+			assert.Nil(err)
+			result := make(M)
+			err = tt.codec.Unmarshal(buf, &result)
+			assert.Nil(err)
+
+			s := Session{
+				data:    result,
+				fetched: true,
+			}
+			assert.Equal("1", s.GetString("a"))
+			assert.Equal(2, s.GetInt("b"))
+			assert.True(s.GetBool("c"))
+			assert.Equal([]string{"1", "2"}, s.GetStringSlice("d"))
+			assert.Equal("g", result["e"].(map[string]interface{})["f"])
+			if tt.codec != JSONCodec {
+				assert.True(now.Equal(result["t"].(time.Time)), "time should be the same after unmarshal")
+			}
+
+			err = tt.codec.Unmarshal([]byte("abcd"), &result)
+			assert.NotNil(err)
+		})
+	}
+}
+
+func TestSessionCodec(t *testing.T) {
+	assert := assert.New(t)
+	store, err := NewMemoryStore(10)
+	ctx := context.Background()
+	assert.Nil(err, "new memory store fail")
+	id := generateID()
+	s := Session{
+		Store: store,
+		ID:    id,
+		Codec: GobCodec,
+	}
+	err = s.Set(ctx, "a", 1)
+	assert.Nil(err)
+	err = s.Commit(ctx, time.Minute)
+	assert.Nil(err)
+
+	s = Session{
+		Store: store,
+		ID:    id,
+		Codec: GobCodec,
+	}
+	_, err = s.Fetch(ctx)
+	assert.Nil(err)
+	assert.Equal(1, s.Get("a"), "gob codec should keep the type of value")
+
+	s = Session{
+		Store: store,
+		ID:    id,
+	}
+	_, err = s.Fetch(ctx)
+	assert.NotNil(err, "json codec should fail to unmarshal gob data")
+}
+
+func TestJSONCodecNumber(t *testing.T) {
+	assert := assert.New(t)
+	store, err := NewMemoryStore(10)
+	ctx := context.Background()
+	assert.Nil(err, "new memory store fail")
+	id := generateID()
+	// 2^53 + 1, it can't be represented by float64
+	var snowflakeID int64 = 9007199254740993
+	s := Session{
+		Store: store,
+		ID:    id,
+	}
+	err = s.SetMap(ctx, map[string]interface{}{
+		"id":    snowflakeID,
+		"max":   int64(9223372036854775807),
+		"min":   int64(-9223372036854775808),
+		"price": 1.5,
+		"ok":    1,
+	})
+	assert.Nil(err)
+	err = s.Commit(ctx, time.Minute)
+	assert.Nil(err)
+
+	s = Session{
+		Store: store,
+		ID:    id,
+	}
+	_, err = s.Fetch(ctx)
+	assert.Nil(err)
+	assert.Equal(snowflakeID, s.GetInt64("id"))
+	assert.Equal(int(snowflakeID), s.GetInt("id"))
+	assert.Equal("9007199254740993", s.GetString("id"))
+	assert.Equal(int64(9223372036854775807), s.GetInt64("max"))
+	assert.Equal(int64(-9223372036854775808), s.GetInt64("min"))
+	assert.Equal(1.5, s.GetFloat64("price"))
+	assert.Equal(1, s.GetInt("price"))
+	assert.Equal(float64(snowflakeID), s.GetFloat64("id"))
+	assert.True(s.GetBool("ok"))
+
+	for _, data := range []string{
+		`{"a":1} {"b":2}`,
+		`{"a":1}}`,
+		`{"a":1}]`,
+		`{"a":1} x`,
+	} {
+		m := make(M)
+		err = JSONCodec.Unmarshal([]byte(data), &m)
+		assert.NotNil(err, "json with extra data should return error")
+		assert.NotNil(json.Unmarshal([]byte(data), &m))
+	}
+	m := make(M)
+	err = JSONCodec.Unmarshal([]byte("{\"a\":1} \n"), &m)
+	assert.Nil(err, "trailing whitespace should be allowed")
+}
@@ -0,0 +1,250 @@
+// MIT License
+
+// Copyright (c) 2020 Tree Xie
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package session
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressAlgorithm the compression algorithm of compress store
+type CompressAlgorithm byte
+
+const (
+	// CompressGzip gzip compression
+	CompressGzip CompressAlgorithm = iota + 1
+	// CompressZstd zstd compression
+	CompressZstd
+	// CompressSnappy snappy compression
+	CompressSnappy
+)
+
+const (
+	// compressMagic the first byte of compressed data, it isn't the first byte
+	// of json, gob or message pack data, so the uncompressed data can be read
+	compressMagic = 0xec
+	// compressRaw the algorithm byte of uncompressed data, it's used
+	// for the data which starts with compressMagic
+	compressRaw           = 0
+	compressHeaderSize    = 2
+	defaultCompressMinLen = 1024
+)
+
+var (
+	// ErrCompressAlgorithm the compression algorithm is not supported
+	ErrCompressAlgorithm = createError("compression algorithm is not supported")
+)
+
+type (
+	// CompressStoreConfig compress store config
+	CompressStoreConfig struct {
+		// Algorithm the compression algorithm, default is gzip
+		Algorithm CompressAlgorithm
+		// MinLength the data is compressed only if its length is greater than
+		// or equal to min length, default is 1024
+		MinLength int
+		// Level the compression level of gzip, default is gzip.DefaultCompression
+		Level int
+	}
+	// CompressStore the store wrapper which compresses the session data,
+	// the compressed data starts with a header(magic and algorithm byte),
+	// and the data without header(small data or stored by old version) is read as it is,
+	// the uncompressed data which starts with the magic byte is stored with a raw header
+	CompressStore struct {
+		store       Store
+		algorithm   CompressAlgorithm
+		minLength   int
+		level       int
+		zstdEncoder *zstd.Encoder
+		zstdDecoder *zstd.Decoder
+	}
+)
+
+// NewCompressStore create a new compress store
+func NewCompressStore(store Store, config CompressStoreConfig) (*CompressStore, error) {
+	algorithm := config.Algorithm
+	if algorithm == 0 {
+		algorithm = CompressGzip
+	}
+	if algorithm > CompressSnappy {
+		return nil, ErrCompressAlgorithm
+	}
+	minLength := config.MinLength
+	if minLength <= 0 {
+		minLength = defaultCompressMinLen
+	}
+	level := config.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	// 校验gzip压缩级别
+	_, err := gzip.NewWriterLevel(io.Discard, level)
+	if err != nil {
+		return nil, err
+	}
+	// zstd的encoder与decoder可并发使用，初始化后复用
+	// decoder用于读取其它算法压缩的数据，因此都初始化
+	zstdDecoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	var zstdEncoder *zstd.Encoder
+	if algorithm == CompressZstd {
+		zstdEncoder, err = zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &CompressStore{
+		store:       store,
+		algorithm:   algorithm,
+		minLength:   minLength,
+		level:       level,
+		zstdEncoder: zstdEncoder,
+		zstdDecoder: zstdDecoder,
+	}, nil
+}
+
+// compress compresses the data if its length reaches min length,
+// the data is kept as it is if the compressed data isn't smaller
+func (cs *CompressStore) compress(data []byte) ([]byte, error) {
+	if len(data) < cs.minLength {
+		return cs.raw(data), nil
+	}
+	buf := make([]byte, compressHeaderSize, compressHeaderSize+len(data))
+	buf[0] = compressMagic
+	buf[1] = byte(cs.algorithm)
+	switch cs.algorithm {
+	case CompressZstd:
+		buf = cs.zstdEncoder.EncodeAll(data, buf)
+	case CompressSnappy:
+		buf = append(buf, snappy.Encode(nil, data)...)
+	default:
+		b := bytes.NewBuffer(buf)
+		w, err := gzip.NewWriterLevel(b, cs.level)
+		if err != nil {
+			return nil, err
+		}
+		_, err = w.Write(data)
+		if err != nil {
+			return nil, err
+		}
+		err = w.Close()
+		if err != nil {
+			return nil, err
+		}
+		buf = b.Bytes()
+	}
+	if len(buf) >= len(data) {
+		return cs.raw(data), nil
+	}
+	return buf, nil
+}
+
+// raw returns the uncompressed data for storing, the raw header is added
+// if the data starts with the magic byte, so it isn't read as compressed data
+func (cs *CompressStore) raw(data []byte) []byte {
+	if len(data) == 0 || data[0] != compressMagic {
+		return data
+	}
+	buf := make([]byte, compressHeaderSize, compressHeaderSize+len(data))
+	buf[0] = compressMagic
+	buf[1] = compressRaw
+	return append(buf, data...)
+}
+
+// decompress decompresses the data by the algorithm of header,
+// the data without header or with unknown algorithm is returned as it is
+func (cs *CompressStore) decompress(data []byte) ([]byte, error) {
+	if len(data) < compressHeaderSize || data[0] != compressMagic {
+		return data, nil
+	}
+	body := data[compressHeaderSize:]
+	switch CompressAlgorithm(data[1]) {
+	case compressRaw:
+		return body, nil
+	case CompressGzip:
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case CompressZstd:
+		return cs.zstdDecoder.DecodeAll(body, nil)
+	case CompressSnappy:
+		return snappy.Decode(nil, body)
+	default:
+		// 未知的算法，数据并非由compress store压缩
+		return data, nil
+	}
+}
+
+// Get get the session data from store and decompress it
+func (cs *CompressStore) Get(ctx context.Context, key string) ([]byte, error) {
+	buf, err := cs.store.Get(ctx, key)
+	if err != nil || len(buf) == 0 {
+		return buf, err
+	}
+	return cs.decompress(buf)
+}
+
+// Set compress the session data and set it to store
+func (cs *CompressStore) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	buf, err := cs.compress(data)
+	if err != nil {
+		return err
+	}
+	return cs.store.Set(ctx, key, buf, ttl)
+}
+
+func (cs *CompressStore) requireEltonContext() bool {
+	return requireEltonContext(cs.store)
+}
+
+// Destroy remove the session data from store
+func (cs *CompressStore) Destroy(ctx context.Context, key string) error {
+	return cs.store.Destroy(ctx, key)
+}
+
+// Touch extend the ttl of session, if the store doesn't implement Toucher,
+// the compressed data is set to store again with the new ttl
+func (cs *CompressStore) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	return touchStore(ctx, cs.store, key, ttl)
+}
+
+// TTL get the remaining ttl of session, it returns 0 if the store doesn't implement Toucher
+func (cs *CompressStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return ttlStore(ctx, cs.store, key)
+}
+
+// CompareAndSwap set the session data if the current data(decompressed) is equal to old.
+// If the store doesn't implement Swapper, the data is set to store without comparing.
+func (cs *CompressStore) CompareAndSwap(ctx context.Context, key string, old, data []byte, ttl time.Duration) (bool, error) {
+	return compareAndSwapStore(ctx, cs.store, key, old, data, ttl, cs.decompress, cs.compress)
+}
@@ -0,0 +1,201 @@
+// MIT License
+
+// Copyright (c) 2020 Tree Xie
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package session
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCompressStore(t *testing.T) {
+	assert := assert.New(t)
+	ms, _ := NewMemoryStore(10)
+	_, err := NewCompressStore(ms, CompressStoreConfig{
+		Algorithm: 10,
+	})
+	assert.Equal(ErrCompressAlgorithm, err)
+
+	_, err = NewCompressStore(ms, CompressStoreConfig{
+		Level: 100,
+	})
+	assert.NotNil(err)
+
+	cs, err := NewCompressStore(ms, CompressStoreConfig{})
+	assert.Nil(err)
+	assert.Equal(CompressGzip, cs.algorithm)
+	assert.Equal(defaultCompressMinLen, cs.minLength)
+}
+
+func TestCompressStore(t *testing.T) {
+	ctx := context.Background()
+	ttl := time.Minute
+	ms, _ := NewMemoryStore(100)
+	largeData := bytes.Repeat([]byte(`{"permission":"read"}`), 100)
+	smallData := []byte(`{"a":1}`)
+	stores := make(map[CompressAlgorithm]*CompressStore)
+	for _, algorithm := range []CompressAlgorithm{
+		CompressGzip,
+		CompressZstd,
+		CompressSnappy,
+	} {
+		cs, err := NewCompressStore(ms, CompressStoreConfig{
+			Algorithm: algorithm,
+		})
+		assert.Nil(t, err)
+		stores[algorithm] = cs
+	}
+
+	t.Run("compress", func(t *testing.T) {
+		assert := assert.New(t)
+		for algorithm, cs := range stores {
+			key := generateID()
+			err := cs.Set(ctx, key, largeData, ttl)
+			assert.Nil(err)
+			raw, _ := ms.Get(ctx, key)
+			assert.Equal([]byte{compressMagic, byte(algorithm)}, raw[:compressHeaderSize])
+			assert.Less(len(raw), len(largeData))
+
+			// all stores can read the compressed data
+			for _, tmp := range stores {
+				buf, err := tmp.Get(ctx, key)
+				assert.Nil(err)
+				assert.Equal(largeData, buf)
+			}
+		}
+	})
+
+	t.Run("uncompressed", func(t *testing.T) {
+		assert := assert.New(t)
+		cs := stores[CompressGzip]
+
+		// small data
+		key := generateID()
+		err := cs.Set(ctx, key, smallData, ttl)
+		assert.Nil(err)
+		raw, _ := ms.Get(ctx, key)
+		assert.Equal(smallData, raw)
+		buf, err := cs.Get(ctx, key)
+		assert.Nil(err)
+		assert.Equal(smallData, buf)
+
+		// incompressible data
+		randomData := make([]byte, 2048)
+		_, _ = rand.Read(randomData)
+		// 避免以magic开头而添加raw header
+		randomData[0] = '{'
+		err = cs.Set(ctx, key, randomData, ttl)
+		assert.Nil(err)
+		raw, _ = ms.Get(ctx, key)
+		assert.Equal(randomData, raw)
+
+		// the data stored by old version
+		_ = ms.Set(ctx, key, largeData, ttl)
+		buf, err = cs.Get(ctx, key)
+		assert.Nil(err)
+		assert.Equal(largeData, buf)
+
+		// not exists
+		buf, err = cs.Get(ctx, generateID())
+		assert.Nil(err)
+		assert.Empty(buf)
+
+		// the raw data starts with magic byte and unknown algorithm
+		_ = ms.Set(ctx, key, []byte{compressMagic, 10, 1}, ttl)
+		buf, err = cs.Get(ctx, key)
+		assert.Nil(err)
+		assert.Equal([]byte{compressMagic, 10, 1}, buf)
+
+		// the raw data starts with magic byte is stored with raw header
+		for _, data := range [][]byte{
+			{compressMagic},
+			{compressMagic, byte(CompressGzip), 1},
+		} {
+			err = cs.Set(ctx, key, data, ttl)
+			assert.Nil(err)
+			raw, _ := ms.Get(ctx, key)
+			assert.Equal(append([]byte{compressMagic, compressRaw}, data...), raw)
+			buf, err = cs.Get(ctx, key)
+			assert.Nil(err)
+			assert.Equal(data, buf)
+		}
+	})
+
+	t.Run("touch and compare and swap", func(t *testing.T) {
+		assert := assert.New(t)
+		cs := stores[CompressZstd]
+		key := generateID()
+		swapped, err := cs.CompareAndSwap(ctx, key, nil, largeData, ttl)
+		assert.Nil(err)
+		assert.True(swapped)
+		swapped, err = cs.CompareAndSwap(ctx, key, smallData, largeData, ttl)
+		assert.Nil(err)
+		assert.False(swapped)
+		swapped, err = cs.CompareAndSwap(ctx, key, largeData, smallData, ttl)
+		assert.Nil(err)
+		assert.True(swapped)
+
+		err = cs.Touch(ctx, key, time.Hour)
+		assert.Nil(err)
+		info, _ := ms.client.Peek(key)
+		assert.GreaterOrEqual(info.ExpiredAt, time.Now().Add(time.Hour).Unix()-1)
+		remaining, err := cs.TTL(ctx, key)
+		assert.Nil(err)
+		assert.Greater(remaining, time.Hour-2*time.Second)
+
+		err = cs.Destroy(ctx, key)
+		assert.Nil(err)
+		buf, _ := cs.Get(ctx, key)
+		assert.Empty(buf)
+	})
+
+	t.Run("session", func(t *testing.T) {
+		assert := assert.New(t)
+		for _, codec := range []Codec{
+			JSONCodec,
+			GobCodec,
+			MsgpackCodec,
+		} {
+			id := generateID()
+			s := &Session{
+				Store: stores[CompressSnappy],
+				ID:    id,
+				Codec: codec,
+			}
+			assert.Nil(s.Set(ctx, "permissions", string(largeData)))
+			assert.Nil(s.Commit(ctx, ttl))
+			raw, _ := ms.Get(ctx, id)
+			assert.Equal(byte(compressMagic), raw[0])
+
+			s = &Session{
+				Store: stores[CompressGzip],
+				ID:    id,
+				Codec: codec,
+			}
+			assert.Equal(string(largeData), s.GetString("permissions"))
+		}
+	})
+}
@@ -0,0 +1,245 @@
+// MIT License
+
+// Copyright (c) 2020 Tree Xie
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package session
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vicanso/elton"
+)
+
+const (
+	defaultCookieChunkSize = 3800
+	defaultCookieMaxChunks = 3
+	// the size of expired time which is encrypted with the session data
+	cookieExpiredAtSize = 8
+)
+
+var (
+	// ErrCookieNameNil the name of cookie store is nil
+	ErrCookieNameNil = createError("cookie store's name is nil")
+	// ErrCookieTooLarge the encrypted session data exceeds the max size of cookies
+	ErrCookieTooLarge = createError("session data is too large for cookie")
+	// ErrContextNotFound the elton context isn't found from context,
+	// the cookie store should be used with the session middleware
+	ErrContextNotFound = createError("elton context is not found")
+)
+
+type (
+	// CookieStoreConfig cookie store config
+	CookieStoreConfig struct {
+		// Keys the keys of AES-GCM(16, 24 or 32 bytes), the first key is used for encryption
+		// and all keys are used for decryption, prepend the new key to rotate keys
+		Keys [][]byte
+		// Name the name of data cookie, the chunks are named as name.1, name.2 and so on
+		Name     string
+		Path     string
+		Domain   string
+		Secure   bool
+		HttpOnly bool
+		SameSite http.SameSite
+		// ChunkSize the max size of each cookie's value, default is 3800
+		ChunkSize int
+		// MaxChunks the max count of data cookies, default is 3
+		MaxChunks int
+	}
+	// CookieStore client-side store, the session data is encrypted and authenticated
+	// by AES-GCM and stored in the cookie, so no server store is required.
+	// It should be used with the session middleware(such as NewByCookie),
+	// which sets the elton context to the context of request.
+	CookieStore struct {
+		keys   keyring
+		config CookieStoreConfig
+	}
+	eltonContextKey struct{}
+	// eltonContextRequirer the optional interface of store,
+	// the store wrapper should forward it to the wrapped store
+	eltonContextRequirer interface {
+		requireEltonContext() bool
+	}
+)
+
+// NewCookieStore create a new cookie store
+func NewCookieStore(config CookieStoreConfig) (*CookieStore, error) {
+	if config.Name == "" {
+		return nil, ErrCookieNameNil
+	}
+	keys, err := newKeyring(config.Keys...)
+	if err != nil {
+		return nil, err
+	}
+	if config.ChunkSize <= 0 {
+		config.ChunkSize = defaultCookieChunkSize
+	}
+	if config.MaxChunks <= 0 {
+		config.MaxChunks = defaultCookieMaxChunks
+	}
+	return &CookieStore{
+		keys:   keys,
+		config: config,
+	}, nil
+}
+
+// requireEltonContext cookie store reads and writes cookies by the elton context
+func (cs *CookieStore) requireEltonContext() bool {
+	return true
+}
+
+// requireEltonContext checks whether the store(or the wrapped store) requires
+// the elton context, such as cookie store
+func requireEltonContext(store Store) bool {
+	requirer, ok := store.(eltonContextRequirer)
+	return ok && requirer.requireEltonContext()
+}
+
+// withEltonContext sets the elton context to the context of request
+func withEltonContext(c *elton.Context) {
+	c.WithContext(context.WithValue(c.Context(), eltonContextKey{}, c))
+}
+
+func getEltonContext(ctx context.Context) (*elton.Context, error) {
+	c, ok := ctx.Value(eltonContextKey{}).(*elton.Context)
+	if !ok {
+		return nil, ErrContextNotFound
+	}
+	return c, nil
+}
+
+// chunkName returns the name of chunk cookie,
+// the first chunk uses the name of config
+func (cs *CookieStore) chunkName(index int) string {
+	if index == 0 {
+		return cs.config.Name
+	}
+	return cs.config.Name + "." + strconv.Itoa(index)
+}
+
+func (cs *CookieStore) addCookie(c *elton.Context, name, value string, maxAge int) {
+	c.AddCookie(&http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     cs.config.Path,
+		Domain:   cs.config.Domain,
+		MaxAge:   maxAge,
+		Secure:   cs.config.Secure,
+		HttpOnly: cs.config.HttpOnly,
+		SameSite: cs.config.SameSite,
+	})
+}
+
+// removeChunks removes the chunk cookies of request from the index
+func (cs *CookieStore) removeChunks(c *elton.Context, index int) {
+	for i := index; i < cs.config.MaxChunks; i++ {
+		name := cs.chunkName(i)
+		_, err := c.Cookie(name)
+		if err != nil {
+			continue
+		}
+		// 设置max age为-1，删除cookie
+		cs.addCookie(c, name, "", -1)
+	}
+}
+
+// Get get the session data from cookie, the invalid data(such as
+// decrypt fail or expired) is treated as not exists
+func (cs *CookieStore) Get(ctx context.Context, key string) ([]byte, error) {
+	c, err := getEltonContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sb := strings.Builder{}
+	for i := 0; i < cs.config.MaxChunks; i++ {
+		cookie, err := c.Cookie(cs.chunkName(i))
+		if err != nil {
+			break
+		}
+		sb.WriteString(cookie.Value)
+	}
+	if sb.Len() == 0 {
+		return nil, nil
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(sb.String())
+	if err != nil {
+		return nil, nil
+	}
+	// 使用session id作为附加数据，避免数据被用于其它session
+	buf, err = cs.keys.open(buf, []byte(key))
+	if err != nil || len(buf) < cookieExpiredAtSize {
+		return nil, nil
+	}
+	expiredAt := int64(binary.BigEndian.Uint64(buf))
+	if expiredAt < time.Now().Unix() {
+		return nil, nil
+	}
+	return buf[cookieExpiredAtSize:], nil
+}
+
+// Set encrypt the session data(with expired time) and set it to cookie,
+// it's split into chunks if it exceeds the chunk size
+func (cs *CookieStore) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	c, err := getEltonContext(ctx)
+	if err != nil {
+		return err
+	}
+	// 过期时间与数据一起加密，避免客户端延长有效期
+	buf := make([]byte, cookieExpiredAtSize+len(data))
+	binary.BigEndian.PutUint64(buf, uint64(time.Now().Add(ttl).Unix()))
+	copy(buf[cookieExpiredAtSize:], data)
+	buf, err = cs.keys.seal(buf, []byte(key))
+	if err != nil {
+		return err
+	}
+	value := base64.RawURLEncoding.EncodeToString(buf)
+	chunkSize := cs.config.ChunkSize
+	if len(value) > chunkSize*cs.config.MaxChunks {
+		return ErrCookieTooLarge
+	}
+	maxAge := int(ttl.Seconds())
+	index := 0
+	for ; len(value) != 0; index++ {
+		size := chunkSize
+		if len(value) < size {
+			size = len(value)
+		}
+		cs.addCookie(c, cs.chunkName(index), value[:size], maxAge)
+		value = value[size:]
+	}
+	// 删除多余的分块
+	cs.removeChunks(c, index)
+	return nil
+}
+
+// Destroy remove the session data cookies
+func (cs *CookieStore) Destroy(ctx context.Context, key string) error {
+	c, err := getEltonContext(ctx)
+	if err != nil {
+		return err
+	}
+	cs.removeChunks(c, 0)
+	return nil
+}
@@ -0,0 +1,356 @@
+// MIT License
+
+// Copyright (c) 2020 Tree Xie
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package session
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vicanso/elton"
+)
+
+var (
+	testEncryptionKey    = []byte("01234567890123456789012345678901")
+	testOldEncryptionKey = []byte("abcdefghijklmnop")
+)
+
+// newCookieStoreContext create the context for cookie store with request cookies
+func newCookieStoreContext(cookies []*http.Cookie) (context.Context, *elton.Context) {
+	req := httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	c := elton.NewContext(httptest.NewRecorder(), req)
+	withEltonContext(c)
+	return c.Context(), c
+}
+
+// responseCookies returns the cookies of response
+func responseCookies(c *elton.Context) []*http.Cookie {
+	return (&http.Response{
+		Header: c.Header(),
+	}).Cookies()
+}
+
+// validCookies returns the cookies which aren't removed
+func validCookies(cookies []*http.Cookie) []*http.Cookie {
+	result := make([]*http.Cookie, 0)
+	for _, cookie := range cookies {
+		if cookie.MaxAge >= 0 {
+			result = append(result, cookie)
+		}
+	}
+	return result
+}
+
+func TestNewCookieStore(t *testing.T) {
+	assert := assert.New(t)
+	_, err := NewCookieStore(CookieStoreConfig{
+		Keys: [][]byte{
+			testEncryptionKey,
+		},
+	})
+	assert.Equal(ErrCookieNameNil, err)
+
+	_, err = NewCookieStore(CookieStoreConfig{
+		Name: "jt.data",
+	})
+	assert.Equal(ErrKeyRequired, err)
+
+	_, err = NewCookieStore(CookieStoreConfig{
+		Name: "jt.data",
+		Keys: [][]byte{
+			[]byte("abc"),
+		},
+	})
+	assert.Equal(ErrKeyInvalid, err)
+
+	cs, err := NewCookieStore(CookieStoreConfig{
+		Name: "jt.data",
+		Keys: [][]byte{
+			testEncryptionKey,
+		},
+	})
+	assert.Nil(err)
+	assert.Equal(defaultCookieChunkSize, cs.config.ChunkSize)
+	assert.Equal(defaultCookieMaxChunks, cs.config.MaxChunks)
+
+	ctx := context.Background()
+	_, err = cs.Get(ctx, "id")
+	assert.Equal(ErrContextNotFound, err)
+	assert.Equal(ErrContextNotFound, cs.Set(ctx, "id", []byte("abc"), time.Minute))
+	assert.Equal(ErrContextNotFound, cs.Destroy(ctx, "id"))
+}
+
+func TestCookieStore(t *testing.T) {
+	id := generateID()
+	data := []byte(`{"a":1}`)
+	cs, _ := NewCookieStore(CookieStoreConfig{
+		Name:     "jt.data",
+		Path:     "/",
+		HttpOnly: true,
+		Keys: [][]byte{
+			testEncryptionKey,
+		},
+		ChunkSize: 150,
+	})
+	setCookies := func(data []byte, ttl time.Duration) []*http.Cookie {
+		ctx, c := newCookieStoreContext(nil)
+		err := cs.Set(ctx, id, data, ttl)
+		assert.Nil(t, err)
+		return responseCookies(c)
+	}
+
+	t.Run("set and get", func(t *testing.T) {
+		assert := assert.New(t)
+		cookies := setCookies(data, time.Minute)
+		assert.Equal(1, len(cookies))
+		assert.Equal("jt.data", cookies[0].Name)
+		assert.Equal(60, cookies[0].MaxAge)
+		assert.True(cookies[0].HttpOnly)
+
+		ctx, _ := newCookieStoreContext(cookies)
+		buf, err := cs.Get(ctx, id)
+		assert.Nil(err)
+		assert.Equal(data, buf)
+
+		// not exists
+		ctx, _ = newCookieStoreContext(nil)
+		buf, err = cs.Get(ctx, id)
+		assert.Nil(err)
+		assert.Nil(buf)
+	})
+
+	t.Run("chunk", func(t *testing.T) {
+		assert := assert.New(t)
+		largeData := bytes.Repeat([]byte("a"), 200)
+		cookies := setCookies(largeData, time.Minute)
+		assert.Equal(3, len(cookies))
+		assert.Equal("jt.data", cookies[0].Name)
+		assert.Equal("jt.data.1", cookies[1].Name)
+		assert.Equal("jt.data.2", cookies[2].Name)
+
+		ctx, _ := newCookieStoreContext(cookies)
+		buf, err := cs.Get(ctx, id)
+		assert.Nil(err)
+		assert.Equal(largeData, buf)
+
+		// the stale chunks should be removed
+		ctx, c := newCookieStoreContext(cookies)
+		err = cs.Set(ctx, id, data, time.Minute)
+		assert.Nil(err)
+		result := responseCookies(c)
+		assert.Equal(3, len(result))
+		assert.Equal(1, len(validCookies(result)))
+		assert.Equal(-1, result[1].MaxAge)
+		assert.Equal(-1, result[2].MaxAge)
+
+		// too large
+		ctx, _ = newCookieStoreContext(nil)
+		err = cs.Set(ctx, id, bytes.Repeat([]byte("a"), 400), time.Minute)
+		assert.Equal(ErrCookieTooLarge, err)
+	})
+
+	t.Run("invalid data", func(t *testing.T) {
+		assert := assert.New(t)
+		cookies := setCookies(data, time.Minute)
+
+		// other session id
+		ctx, _ := newCookieStoreContext(cookies)
+		buf, err := cs.Get(ctx, generateID())
+		assert.Nil(err)
+		assert.Nil(buf, "data of other session should be invalid")
+
+		// tampered
+		value := cookies[0].Value
+		cookies[0].Value = strings.ToUpper(value[:10]) + value[10:]
+		ctx, _ = newCookieStoreContext(cookies)
+		buf, err = cs.Get(ctx, id)
+		assert.Nil(err)
+		assert.Nil(buf, "tampered data should be invalid")
+
+		// not base64
+		cookies[0].Value = "!!!"
+		ctx, _ = newCookieStoreContext(cookies)
+		buf, err = cs.Get(ctx, id)
+		assert.Nil(err)
+		assert.Nil(buf)
+
+		// expired
+		cookies = setCookies(data, -time.Second)
+		cookies[0].MaxAge = 0
+		ctx, _ = newCookieStoreContext(cookies)
+		buf, err = cs.Get(ctx, id)
+		assert.Nil(err)
+		assert.Nil(buf, "expired data should be invalid")
+	})
+
+	t.Run("key rotation", func(t *testing.T) {
+		assert := assert.New(t)
+		oldStore, _ := NewCookieStore(CookieStoreConfig{
+			Name: "jt.data",
+			Keys: [][]byte{
+				testOldEncryptionKey,
+			},
+		})
+		newStore, _ := NewCookieStore(CookieStoreConfig{
+			Name: "jt.data",
+			Keys: [][]byte{
+				testEncryptionKey,
+				testOldEncryptionKey,
+			},
+		})
+		ctx, c := newCookieStoreContext(nil)
+		err := oldStore.Set(ctx, id, data, time.Minute)
+		assert.Nil(err)
+		cookies := responseCookies(c)
+
+		ctx, _ = newCookieStoreContext(cookies)
+		buf, err := newStore.Get(ctx, id)
+		assert.Nil(err)
+		assert.Equal(data, buf, "data encrypted by old key should be decrypted")
+
+		// encrypt by the new key
+		ctx, c = newCookieStoreContext(nil)
+		err = newStore.Set(ctx, id, data, time.Minute)
+		assert.Nil(err)
+		ctx, _ = newCookieStoreContext(responseCookies(c))
+		buf, err = oldStore.Get(ctx, id)
+		assert.Nil(err)
+		assert.Nil(buf, "data should be encrypted by the new key")
+	})
+
+	t.Run("destroy", func(t *testing.T) {
+		assert := assert.New(t)
+		cookies := setCookies(bytes.Repeat([]byte("a"), 100), time.Minute)
+		assert.Equal(2, len(cookies))
+		ctx, c := newCookieStoreContext(cookies)
+		err := cs.Destroy(ctx, id)
+		assert.Nil(err)
+		result := responseCookies(c)
+		assert.Equal(2, len(result))
+		assert.Empty(validCookies(result))
+	})
+}
+
+func TestCookieStoreMiddleware(t *testing.T) {
+	cs, _ := NewCookieStore(CookieStoreConfig{
+		Name:     "jt.data",
+		Path:     "/",
+		HttpOnly: true,
+		Keys: [][]byte{
+			testEncryptionKey,
+		},
+	})
+	compressStore, _ := NewCompressStore(cs, CompressStoreConfig{})
+	encryptedStore, _ := NewEncryptedStore(cs, testOldEncryptionKey)
+	for name, store := range map[string]Store{
+		"cookie store":    cs,
+		"compress store":  compressStore,
+		"encrypted store": encryptedStore,
+	} {
+		store := store
+		t.Run(name, func(t *testing.T) {
+			testCookieStoreMiddleware(t, store)
+		})
+	}
+}
+
+func TestRequireEltonContext(t *testing.T) {
+	assert := assert.New(t)
+	cs, _ := NewCookieStore(CookieStoreConfig{
+		Keys: [][]byte{
+			testEncryptionKey,
+		},
+	})
+	ms, _ := NewMemoryStore(10)
+	compressStore, _ := NewCompressStore(cs, CompressStoreConfig{})
+	encryptedStore, _ := NewEncryptedStore(compressStore, testOldEncryptionKey)
+	assert.True(requireEltonContext(cs))
+	assert.True(requireEltonContext(encryptedStore), "wrapped cookie store should require elton context")
+	assert.False(requireEltonContext(ms))
+	msCompressStore, _ := NewCompressStore(ms, CompressStoreConfig{})
+	assert.False(requireEltonContext(msCompressStore))
+
+	// the request isn't cloned for the store without elton context
+	fn := NewByCookie(CookieConfig{
+		Store:   ms,
+		Expired: time.Hour,
+		GenID:   generateID,
+		Name:    "jt",
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+	c := elton.NewContext(httptest.NewRecorder(), req)
+	c.Next = func() error {
+		return nil
+	}
+	assert.Nil(fn(c))
+	assert.Same(req, c.Request)
+	_, err := getEltonContext(c.Context())
+	assert.Equal(ErrContextNotFound, err)
+}
+
+func testCookieStoreMiddleware(t *testing.T, store Store) {
+	assert := assert.New(t)
+	fn := NewByCookie(CookieConfig{
+		Store:   store,
+		Expired: time.Hour,
+		GenID:   generateID,
+		Name:    "jt",
+		Path:    "/",
+		MaxAge:  3600,
+	})
+
+	req := httptest.NewRequest("POST", "/login", nil)
+	c := elton.NewContext(httptest.NewRecorder(), req)
+	c.Next = func() error {
+		return MustGet(c).Set(c.Context(), "account", "tree")
+	}
+	err := fn(c)
+	assert.Nil(err)
+	cookies := responseCookies(c)
+	assert.Equal(2, len(cookies))
+	assert.Equal("jt", cookies[0].Name)
+	assert.Equal("jt.data", cookies[1].Name)
+
+	req = httptest.NewRequest("GET", "/me", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	c = elton.NewContext(httptest.NewRecorder(), req)
+	c.Next = func() error {
+		se := MustGet(c)
+		assert.Equal("tree", se.GetString("account"))
+		return se.Destroy(c.Context())
+	}
+	err = fn(c)
+	assert.Nil(err)
+	cookies = responseCookies(c)
+	assert.Equal(2, len(cookies))
+	assert.Empty(validCookies(cookies), "cookies should be removed after destroy")
+}
@@ -0,0 +1,112 @@
+// MIT License
+
+// Copyright (c) 2020 Tree Xie
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package session
+
+import (
+	"context"
+	"time"
+)
+
+// EncryptedStore the store wrapper which encrypts the session data by AES-GCM,
+// the data is encrypted by the newest key(the first key) and decrypted by all keys,
+// so the key can be rotated by prepending the new key
+type EncryptedStore struct {
+	store Store
+	keys  keyring
+}
+
+// NewEncryptedStore create a new encrypted store, the keys should be 16, 24 or 32 bytes
+func NewEncryptedStore(store Store, keys ...[]byte) (*EncryptedStore, error) {
+	ring, err := newKeyring(keys...)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedStore{
+		store: store,
+		keys:  ring,
+	}, nil
+}
+
+// decrypt decrypts the data of store, the session id is used as additional data
+func (es *EncryptedStore) decrypt(key string, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return es.keys.open(data, []byte(key))
+}
+
+// encrypt encrypts the data with the newest key, the session id is used as additional data
+// to avoid the data being used by other session
+func (es *EncryptedStore) encrypt(key string, data []byte) ([]byte, error) {
+	return es.keys.seal(data, []byte(key))
+}
+
+// Get get the session data from store and decrypt it,
+// it returns ErrDecrypt if the data can't be decrypted by any key
+func (es *EncryptedStore) Get(ctx context.Context, key string) ([]byte, error) {
+	buf, err := es.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return es.decrypt(key, buf)
+}
+
+// Set encrypt the session data with the newest key and set it to store
+func (es *EncryptedStore) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	buf, err := es.encrypt(key, data)
+	if err != nil {
+		return err
+	}
+	return es.store.Set(ctx, key, buf, ttl)
+}
+
+func (es *EncryptedStore) requireEltonContext() bool {
+	return requireEltonContext(es.store)
+}
+
+// Destroy remove the session data from store
+func (es *EncryptedStore) Destroy(ctx context.Context, key string) error {
+	return es.store.Destroy(ctx, key)
+}
+
+// Touch extend the ttl of session, if the store doesn't implement Toucher,
+// the encrypted data is set to store again with the new ttl
+func (es *EncryptedStore) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	return touchStore(ctx, es.store, key, ttl)
+}
+
+// TTL get the remaining ttl of session, it returns 0 if the store doesn't implement Toucher
+func (es *EncryptedStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return ttlStore(ctx, es.store, key)
+}
+
+// CompareAndSwap set the session data if the current data(decrypted) is equal to old.
+// As the ciphertext is different for each encryption, the current encrypted data
+// is used for the compare and swap of store. If the store doesn't implement Swapper,
+// the data is set to store without comparing.
+func (es *EncryptedStore) CompareAndSwap(ctx context.Context, key string, old, data []byte, ttl time.Duration) (bool, error) {
+	return compareAndSwapStore(ctx, es.store, key, old, data, ttl, func(buf []byte) ([]byte, error) {
+		return es.decrypt(key, buf)
+	}, func(buf []byte) ([]byte, error) {
+		return es.encrypt(key, buf)
+	})
+}
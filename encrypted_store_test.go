@@ -0,0 +1,179 @@
+// MIT License
+
+// Copyright (c) 2020 Tree Xie
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package session
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptedStore(t *testing.T) {
+	key := generateID()
+	data := []byte(`{"account":"tree"}`)
+	ttl := time.Minute
+	ctx := context.Background()
+	ms, _ := NewMemoryStore(10)
+	es, err := NewEncryptedStore(ms, testEncryptionKey)
+	assert.Nil(t, err)
+
+	t.Run("new", func(t *testing.T) {
+		assert := assert.New(t)
+		_, err := NewEncryptedStore(ms)
+		assert.Equal(ErrKeyRequired, err)
+		_, err = NewEncryptedStore(ms, []byte("abc"))
+		assert.Equal(ErrKeyInvalid, err)
+	})
+
+	t.Run("set and get", func(t *testing.T) {
+		assert := assert.New(t)
+		buf, err := es.Get(ctx, key)
+		assert.Nil(err)
+		assert.Empty(buf)
+
+		err = es.Set(ctx, key, data, ttl)
+		assert.Nil(err)
+		raw, _ := ms.Get(ctx, key)
+		assert.False(bytes.Contains(raw, []byte("tree")), "data should be encrypted")
+
+		buf, err = es.Get(ctx, key)
+		assert.Nil(err)
+		assert.Equal(data, buf)
+
+		// the encrypted data of other session
+		otherKey := generateID()
+		_ = ms.Set(ctx, otherKey, raw, ttl)
+		_, err = es.Get(ctx, otherKey)
+		assert.Equal(ErrDecrypt, err)
+	})
+
+	t.Run("key rotation", func(t *testing.T) {
+		assert := assert.New(t)
+		oldStore, _ := NewEncryptedStore(ms, testOldEncryptionKey)
+		newStore, _ := NewEncryptedStore(ms, testEncryptionKey, testOldEncryptionKey)
+		tmpKey := generateID()
+		err := oldStore.Set(ctx, tmpKey, data, ttl)
+		assert.Nil(err)
+		buf, err := newStore.Get(ctx, tmpKey)
+		assert.Nil(err)
+		assert.Equal(data, buf, "data encrypted by old key should be decrypted")
+
+		// re-encrypt with the newest key
+		err = newStore.Set(ctx, tmpKey, buf, ttl)
+		assert.Nil(err)
+		_, err = oldStore.Get(ctx, tmpKey)
+		assert.Equal(ErrDecrypt, err)
+		buf, err = es.Get(ctx, tmpKey)
+		assert.Nil(err)
+		assert.Equal(data, buf)
+	})
+
+	t.Run("touch", func(t *testing.T) {
+		assert := assert.New(t)
+		err := es.Touch(ctx, key, time.Hour)
+		assert.Nil(err)
+		info, _ := ms.client.Peek(key)
+		assert.GreaterOrEqual(info.ExpiredAt, time.Now().Add(time.Hour).Unix()-1)
+
+		// the store doesn't implement Toucher
+		tmp, _ := NewEncryptedStore(struct{ Store }{ms}, testEncryptionKey)
+		err = tmp.Touch(ctx, key, 2*time.Hour)
+		assert.Nil(err)
+		info, _ = ms.client.Peek(key)
+		assert.GreaterOrEqual(info.ExpiredAt, time.Now().Add(2*time.Hour).Unix()-1)
+		buf, err := es.Get(ctx, key)
+		assert.Nil(err)
+		assert.Equal(data, buf)
+
+		ttl, err := es.TTL(ctx, key)
+		assert.Nil(err)
+		assert.Greater(ttl, time.Hour)
+		ttl, err = tmp.TTL(ctx, key)
+		assert.Nil(err)
+		assert.Equal(time.Duration(0), ttl, "ttl should be unknown")
+	})
+
+	t.Run("compare and swap", func(t *testing.T) {
+		assert := assert.New(t)
+		tmpKey := generateID()
+		swapped, err := es.CompareAndSwap(ctx, tmpKey, nil, data, ttl)
+		assert.Nil(err)
+		assert.True(swapped)
+		swapped, err = es.CompareAndSwap(ctx, tmpKey, nil, []byte("abc"), ttl)
+		assert.Nil(err)
+		assert.False(swapped, "the session exists, it should not be swapped")
+
+		swapped, err = es.CompareAndSwap(ctx, tmpKey, data, []byte("abc"), ttl)
+		assert.Nil(err)
+		assert.True(swapped)
+		buf, _ := es.Get(ctx, tmpKey)
+		assert.Equal([]byte("abc"), buf)
+
+		swapped, err = es.CompareAndSwap(ctx, tmpKey, data, []byte("def"), ttl)
+		assert.Nil(err)
+		assert.False(swapped, "the data is changed, it should not be swapped")
+
+		// the store doesn't implement Swapper
+		tmp, _ := NewEncryptedStore(struct{ Store }{ms}, testEncryptionKey)
+		swapped, err = tmp.CompareAndSwap(ctx, tmpKey, data, []byte("def"), ttl)
+		assert.Nil(err)
+		assert.True(swapped)
+		buf, _ = es.Get(ctx, tmpKey)
+		assert.Equal([]byte("def"), buf)
+	})
+
+	t.Run("destroy", func(t *testing.T) {
+		assert := assert.New(t)
+		err := es.Destroy(ctx, key)
+		assert.Nil(err)
+		buf, err := es.Get(ctx, key)
+		assert.Nil(err)
+		assert.Empty(buf)
+	})
+
+	t.Run("session", func(t *testing.T) {
+		assert := assert.New(t)
+		id := generateID()
+		s := &Session{
+			Store: es,
+			ID:    id,
+		}
+		assert.Nil(s.Set(ctx, "a", 1))
+		assert.Nil(s.Commit(ctx, ttl))
+		s = &Session{
+			Store: es,
+			ID:    id,
+		}
+		assert.Nil(s.Set(ctx, "b", 2))
+		assert.Nil(s.Commit(ctx, ttl))
+
+		s = &Session{
+			Store: es,
+			ID:    id,
+		}
+		assert.Equal(1, s.GetInt("a"))
+		assert.Equal(2, s.GetInt("b"))
+	})
+}
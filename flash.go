@@ -0,0 +1,83 @@
+// MIT License
+
+// Copyright (c) 2020 Tree Xie
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package session
+
+import (
+	"context"
+
+	"github.com/spf13/cast"
+)
+
+// getFlashes gets the copy of flash messages
+func (s *Session) getFlashes() map[string]interface{} {
+	flashes := make(map[string]interface{})
+	for category, messages := range cast.ToStringMap(s.data[FlashKey]) {
+		flashes[category] = cast.ToStringSlice(messages)
+	}
+	return flashes
+}
+
+// Flash add the one-shot message of category to session,
+// it will be removed after read by Flashes
+func (s *Session) Flash(ctx context.Context, category, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	flashes := s.getFlashes()
+	messages := cast.ToStringSlice(flashes[category])
+	flashes[category] = append(messages, message)
+	return s.setMap(ctx, map[string]interface{}{
+		FlashKey: flashes,
+	})
+}
+
+// Flashes get the messages of category and remove them from session
+func (s *Session) Flashes(ctx context.Context, category string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	flashes := s.getFlashes()
+	messages := cast.ToStringSlice(flashes[category])
+	if len(messages) == 0 {
+		return nil, nil
+	}
+	delete(flashes, category)
+	var value interface{}
+	// 无flash消息则删除
+	if len(flashes) != 0 {
+		value = flashes
+	}
+	err = s.setMap(ctx, map[string]interface{}{
+		FlashKey: value,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
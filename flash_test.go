@@ -0,0 +1,95 @@
+// MIT License
+
+// Copyright (c) 2020 Tree Xie
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlash(t *testing.T) {
+	ctx := context.Background()
+	for _, codec := range []Codec{
+		JSONCodec,
+		GobCodec,
+		MsgpackCodec,
+	} {
+		assert := assert.New(t)
+		store, err := NewMemoryStore(10)
+		assert.Nil(err, "new memory store fail")
+		id := generateID()
+		s := Session{
+			Store: store,
+			ID:    id,
+			Codec: codec,
+		}
+		err = s.Flash(ctx, "info", "saved")
+		assert.Nil(err)
+		err = s.Flash(ctx, "info", "sent")
+		assert.Nil(err)
+		err = s.Flash(ctx, "error", "fail")
+		assert.Nil(err)
+		err = s.Commit(ctx, time.Minute)
+		assert.Nil(err)
+
+		// next request
+		s = Session{
+			Store: store,
+			ID:    id,
+			Codec: codec,
+		}
+		// flash is reserved, it's excluded by has and keys
+		assert.False(s.Has(FlashKey))
+		assert.Empty(s.Keys())
+		messages, err := s.Flashes(ctx, "info")
+		assert.Nil(err)
+		assert.Equal([]string{"saved", "sent"}, messages)
+		assert.True(s.modified, "read flashes should modify session")
+
+		messages, err = s.Flashes(ctx, "info")
+		assert.Nil(err)
+		assert.Empty(messages, "flashes should be removed after read")
+		err = s.Commit(ctx, time.Minute)
+		assert.Nil(err)
+
+		s = Session{
+			Store: store,
+			ID:    id,
+			Codec: codec,
+		}
+		messages, err = s.Flashes(ctx, "info")
+		assert.Nil(err)
+		assert.Empty(messages, "the removal of flashes should be committed")
+		messages, err = s.Flashes(ctx, "error")
+		assert.Nil(err)
+		assert.Equal([]string{"fail"}, messages)
+		assert.Nil(s.Get(FlashKey), "flash key should be removed if no flashes")
+
+		s.EnableReadonly()
+		err = s.Flash(ctx, "info", "saved")
+		assert.Equal(ErrIsReadonly, err)
+	}
+}
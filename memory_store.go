@@ -23,10 +23,12 @@
 package session
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
-	"io/ioutil"
 	"net/http"
+	"os"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -45,16 +47,34 @@ var (
 	defaultInterval = 60 * time.Second
 )
 
-const (
-	flushStatusStop = iota
-	flushStatusRunning
-)
-
 type (
 	// MemoryStore memory store for session
 	MemoryStore struct {
-		client      *lru.Cache[string, *MemoryStoreInfo]
-		flushStatus int32
+		// 64位的原子操作字段需要放在最前，保证32位系统的对齐
+		// the count of expired sessions removed by clean
+		reaped uint64
+		// the count of flush failures
+		flushFailures uint64
+		// the unix nano time of last successful flush
+		lastFlushedAt int64
+
+		client *lru.Cache[string, *MemoryStoreInfo]
+		// serialize the modification of session(compare and swap)
+		mutex      sync.Mutex
+		flushMutex sync.Mutex
+		flushStop  chan struct{}
+		flushDone  chan struct{}
+		// serialize the writing of snapshot
+		writeMutex sync.Mutex
+		// the snapshot file
+		saveAs string
+		// keep the previous snapshot
+		keepPrevious bool
+		// the callback of persistence error
+		onError    func(err error)
+		cleanMutex sync.Mutex
+		cleanStop  chan struct{}
+		cleanDone  chan struct{}
 	}
 	// MemoryStoreInfo memory store info
 	MemoryStoreInfo struct {
@@ -68,6 +88,17 @@ type (
 		SaveAs string
 		// Interval save interval
 		Interval time.Duration
+		// KeepPrevious keep the previous snapshot as SaveAs + ".prev",
+		// it will be used to restore if the current snapshot is invalid
+		KeepPrevious bool
+		// CleanInterval the interval of removing expired sessions,
+		// the cleaner won't run if it's 0
+		CleanInterval time.Duration
+		// OnError the callback of persistence error(restore and interval flush)
+		OnError func(err error)
+		// StrictRestore returns the restore error from NewMemoryStoreByConfig,
+		// otherwise the error is passed to OnError and ignored
+		StrictRestore bool
 	}
 )
 
@@ -96,12 +127,77 @@ func (ms *MemoryStore) Set(_ context.Context, key string, data []byte, ttl time.
 		err = ErrNotInit
 		return
 	}
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.add(key, data, ttl)
+	return
+}
+
+func (ms *MemoryStore) add(key string, data []byte, ttl time.Duration) {
 	expiredAt := time.Now().Unix() + int64(ttl.Seconds())
 	info := &MemoryStoreInfo{
 		ExpiredAt: expiredAt,
 		Data:      data,
 	}
-	client.Add(key, info)
+	ms.client.Add(key, info)
+}
+
+// CompareAndSwap set the session to memory if the current data is equal to old,
+// the old is nil means the session should not exist
+func (ms *MemoryStore) CompareAndSwap(_ context.Context, key string, old, data []byte, ttl time.Duration) (swapped bool, err error) {
+	client := ms.client
+	if client == nil {
+		err = ErrNotInit
+		return
+	}
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	var current []byte
+	info, found := client.Peek(key)
+	if found && info.ExpiredAt >= time.Now().Unix() {
+		current = info.Data
+	}
+	if !bytes.Equal(current, old) {
+		return
+	}
+	ms.add(key, data, ttl)
+	swapped = true
+	return
+}
+
+// Touch extend the ttl of session
+func (ms *MemoryStore) Touch(_ context.Context, key string, ttl time.Duration) (err error) {
+	client := ms.client
+	if client == nil {
+		err = ErrNotInit
+		return
+	}
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	info, found := client.Peek(key)
+	if !found || info.ExpiredAt < time.Now().Unix() {
+		return
+	}
+	// 使用新的info，避免与读取的数据竞争
+	ms.add(key, info.Data, ttl)
+	return
+}
+
+// TTL get the remaining ttl of session, it returns 0 if the session isn't exists
+func (ms *MemoryStore) TTL(_ context.Context, key string) (ttl time.Duration, err error) {
+	client := ms.client
+	if client == nil {
+		err = ErrNotInit
+		return
+	}
+	info, found := client.Peek(key)
+	if !found {
+		return
+	}
+	ttl = time.Until(time.Unix(info.ExpiredAt, 0))
+	if ttl < 0 {
+		ttl = 0
+	}
 	return
 }
 
@@ -112,45 +208,239 @@ func (ms *MemoryStore) Destroy(_ context.Context, key string) (err error) {
 		err = ErrNotInit
 		return
 	}
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
 	client.Remove(key)
 	return
 }
 
-func (ms *MemoryStore) intervalFlush(saveAs string, interval time.Duration) {
+func (ms *MemoryStore) snapshot() map[string]*MemoryStoreInfo {
 	client := ms.client
-	if client == nil {
+	now := time.Now().Unix()
+	m := make(map[string]*MemoryStoreInfo)
+	for _, key := range client.Keys() {
+		info, found := client.Peek(key)
+		if !found {
+			continue
+		}
+		if info.ExpiredAt < now {
+			continue
+		}
+		m[key] = info
+	}
+	return m
+}
+
+func (ms *MemoryStore) flush() error {
+	if ms.client == nil {
+		return ErrNotInit
+	}
+	ms.writeMutex.Lock()
+	defer ms.writeMutex.Unlock()
+	buf, err := json.Marshal(ms.snapshot())
+	if err == nil {
+		err = writeSnapshot(ms.saveAs, buf, ms.keepPrevious)
+	}
+	if err != nil {
+		atomic.AddUint64(&ms.flushFailures, 1)
+		return err
+	}
+	atomic.StoreInt64(&ms.lastFlushedAt, time.Now().UnixNano())
+	return nil
+}
+
+func (ms *MemoryStore) emitError(err error) {
+	if err != nil && ms.onError != nil {
+		ms.onError(err)
+	}
+}
+
+// LastFlushedAt get the time of last successful flush,
+// it returns zero time if never flushed
+func (ms *MemoryStore) LastFlushedAt() time.Time {
+	value := atomic.LoadInt64(&ms.lastFlushedAt)
+	if value == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, value)
+}
+
+// FlushFailures get the count of flush failures
+func (ms *MemoryStore) FlushFailures() uint64 {
+	return atomic.LoadUint64(&ms.flushFailures)
+}
+
+func (ms *MemoryStore) restore() error {
+	m, err := readSnapshot(ms.saveAs)
+	if err != nil {
+		// 当前snapshot无效时，尝试使用上一版本
+		prev, e := readSnapshot(ms.saveAs + snapshotPreviousSuffix)
+		if e != nil {
+			// 首次启动无snapshot文件，不认为出错
+			if os.IsNotExist(err) && os.IsNotExist(e) {
+				return nil
+			}
+			return err
+		}
+		// 已从上一版本恢复，仅回调通知
+		ms.emitError(err)
+		m = prev
+	}
+	for key, value := range m {
+		ms.client.Add(key, value)
+	}
+	return nil
+}
+
+func (ms *MemoryStore) intervalFlush(interval time.Duration) {
+	if ms.client == nil {
+		return
+	}
+	ms.flushMutex.Lock()
+	defer ms.flushMutex.Unlock()
+	if ms.flushStop != nil {
 		return
 	}
-	atomic.StoreInt32(&ms.flushStatus, flushStatusRunning)
 	if interval < time.Second {
 		interval = defaultInterval
 	}
-	ticker := time.NewTicker(interval)
-	for range ticker.C {
-		if atomic.LoadInt32(&ms.flushStatus) == flushStatusStop {
-			return
-		}
-		keys := client.Keys()
-		m := make(map[string]*MemoryStoreInfo)
-		for _, key := range keys {
-			info, found := client.Get(key)
-			if !found {
-				continue
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	ms.flushStop = stop
+	ms.flushDone = done
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				ms.emitError(ms.flush())
 			}
-			if info.ExpiredAt < time.Now().Unix() {
-				continue
+		}
+	}()
+}
+
+// stopFlush stops the flush goroutine and returns
+// a channel which will be closed when the goroutine exits
+func (ms *MemoryStore) stopFlush() <-chan struct{} {
+	ms.flushMutex.Lock()
+	defer ms.flushMutex.Unlock()
+	if ms.flushStop == nil {
+		return nil
+	}
+	close(ms.flushStop)
+	done := ms.flushDone
+	ms.flushStop = nil
+	ms.flushDone = nil
+	return done
+}
+
+// Clean remove the expired sessions from memory,
+// it returns the count of removed sessions
+func (ms *MemoryStore) Clean() int {
+	client := ms.client
+	if client == nil {
+		return 0
+	}
+	now := time.Now().Unix()
+	count := 0
+	for _, key := range client.Keys() {
+		// 使用peek避免更新lru的顺序
+		info, found := client.Peek(key)
+		if !found || info.ExpiredAt >= now {
+			continue
+		}
+		if ms.removeExpired(key, info) {
+			count++
+		}
+	}
+	atomic.AddUint64(&ms.reaped, uint64(count))
+	return count
+}
+
+// removeExpired removes the expired session if it isn't set again
+func (ms *MemoryStore) removeExpired(key string, info *MemoryStoreInfo) bool {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	// 再次确认未被重新设置，避免删除新的session
+	current, found := ms.client.Peek(key)
+	if !found || current != info {
+		return false
+	}
+	return ms.client.Remove(key)
+}
+
+// Reaped get the total count of expired sessions removed by clean
+func (ms *MemoryStore) Reaped() uint64 {
+	return atomic.LoadUint64(&ms.reaped)
+}
+
+func (ms *MemoryStore) intervalClean(interval time.Duration) {
+	ms.cleanMutex.Lock()
+	defer ms.cleanMutex.Unlock()
+	if ms.cleanStop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	ms.cleanStop = stop
+	ms.cleanDone = done
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				ms.Clean()
 			}
-			m[key] = info
 		}
+	}()
+}
 
-		buf, _ := json.Marshal(&m)
-		_ = ioutil.WriteFile(saveAs, buf, 0600)
+// StopClean stop the cleaner of expired sessions,
+// it waits for the running clean to complete
+func (ms *MemoryStore) StopClean() {
+	ms.cleanMutex.Lock()
+	defer ms.cleanMutex.Unlock()
+	if ms.cleanStop == nil {
+		return
 	}
+	close(ms.cleanStop)
+	<-ms.cleanDone
+	ms.cleanStop = nil
+	ms.cleanDone = nil
 }
 
-// StopFlush stop flush
+// StopFlush stop flush, the snapshot won't be written any more
 func (ms *MemoryStore) StopFlush() {
-	atomic.StoreInt32(&ms.flushStatus, flushStatusStop)
+	ms.stopFlush()
+}
+
+// Close stops the cleaner and the interval flush, then writes the snapshot
+// to file synchronously(if SaveAs is set). It should be called when
+// the server is shutting down, otherwise the sessions modified
+// after the last flush will be lost.
+func (ms *MemoryStore) Close(ctx context.Context) error {
+	ms.StopClean()
+	done := ms.stopFlush()
+	if done != nil {
+		// 等待正在执行的flush完成
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if ms.saveAs == "" {
+		return nil
+	}
+	return ms.flush()
 }
 
 // NewMemoryStore create new memory store instance
@@ -173,16 +463,24 @@ func NewMemoryStoreByConfig(config MemoryStoreConfig) (store *MemoryStore, err e
 	}
 	file := config.SaveAs
 	if file != "" {
-		// 从文件中恢复
-		buf, _ := ioutil.ReadFile(file)
-		m := make(map[string]*MemoryStoreInfo)
-		// 如果读取失败，则忽略
-		_ = json.Unmarshal(buf, &m)
-		for key, value := range m {
-			store.client.Add(key, value)
+		store.saveAs = file
+		store.keepPrevious = config.KeepPrevious
+		store.onError = config.OnError
+		// 从文件中恢复，如果非strict模式，则忽略出错
+		err = store.restore()
+		if err != nil {
+			if config.StrictRestore {
+				return nil, err
+			}
+			store.emitError(err)
+			err = nil
 		}
 		// 定时写入文件
-		go store.intervalFlush(file, config.Interval)
+		store.intervalFlush(config.Interval)
+	}
+	if config.CleanInterval > 0 {
+		// 定时清除过期的session
+		store.intervalClean(config.CleanInterval)
 	}
 	return
 }
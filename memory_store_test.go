@@ -24,6 +24,8 @@ package session
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -67,6 +69,57 @@ func TestMemoryStore(t *testing.T) {
 		assert.Equal(data, buf, "the data isn't the same after set")
 	})
 
+	t.Run("touch", func(t *testing.T) {
+		assert := assert.New(t)
+		err := ms.Touch(ctx, key, time.Hour)
+		assert.Nil(err)
+		info, _ := ms.client.Peek(key)
+		assert.GreaterOrEqual(info.ExpiredAt, time.Now().Add(time.Hour).Unix()-1, "ttl should be extended")
+		assert.Equal(data, info.Data)
+
+		// touch not exists data
+		err = ms.Touch(ctx, generateID(), time.Hour)
+		assert.Nil(err)
+		assert.Equal(ErrNotInit, (&MemoryStore{}).Touch(ctx, key, time.Hour))
+
+		ttl, err := ms.TTL(ctx, key)
+		assert.Nil(err)
+		assert.Greater(ttl, time.Hour-2*time.Second)
+		assert.LessOrEqual(ttl, time.Hour)
+		ttl, err = ms.TTL(ctx, generateID())
+		assert.Nil(err)
+		assert.Equal(time.Duration(0), ttl)
+		_, err = (&MemoryStore{}).TTL(ctx, key)
+		assert.Equal(ErrNotInit, err)
+	})
+
+	t.Run("compare and swap", func(t *testing.T) {
+		assert := assert.New(t)
+		tmpKey := generateID()
+		// the session should not exist
+		swapped, err := ms.CompareAndSwap(ctx, tmpKey, nil, data, ttl)
+		assert.Nil(err)
+		assert.True(swapped)
+		swapped, err = ms.CompareAndSwap(ctx, tmpKey, nil, []byte("abc"), ttl)
+		assert.Nil(err)
+		assert.False(swapped, "the session exists, it should not be swapped")
+
+		swapped, err = ms.CompareAndSwap(ctx, tmpKey, data, []byte("abc"), ttl)
+		assert.Nil(err)
+		assert.True(swapped)
+		buf, _ := ms.Get(ctx, tmpKey)
+		assert.Equal([]byte("abc"), buf)
+
+		swapped, err = ms.CompareAndSwap(ctx, tmpKey, data, []byte("def"), ttl)
+		assert.Nil(err)
+		assert.False(swapped, "the data is changed, it should not be swapped")
+		buf, _ = ms.Get(ctx, tmpKey)
+		assert.Equal([]byte("abc"), buf)
+
+		_, err = (&MemoryStore{}).CompareAndSwap(ctx, tmpKey, nil, data, ttl)
+		assert.Equal(ErrNotInit, err)
+	})
+
 	t.Run("destroy", func(t *testing.T) {
 		assert := assert.New(t)
 		err := ms.Destroy(ctx, key)
@@ -116,3 +169,140 @@ func TestMemoryStoreFlush(t *testing.T) {
 	assert.Nil(err)
 	assert.Equal(data, value, "load store from memory fail")
 }
+
+func TestMemoryStoreClean(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	store, err := NewMemoryStoreByConfig(MemoryStoreConfig{
+		Size:          10,
+		CleanInterval: 10 * time.Millisecond,
+	})
+	assert.Nil(err, "new memory store fail")
+	defer store.StopClean()
+
+	_ = store.Set(ctx, "a", []byte("a"), -time.Second)
+	_ = store.Set(ctx, "b", []byte("b"), -time.Second)
+	_ = store.Set(ctx, "c", []byte("c"), time.Minute)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(1, store.client.Len(), "expired sessions should be removed")
+	assert.Equal(uint64(2), store.Reaped())
+
+	data, err := store.Get(ctx, "c")
+	assert.Nil(err)
+	assert.Equal([]byte("c"), data)
+
+	store.StopClean()
+	// stop twice should be ok
+	store.StopClean()
+	_ = store.Set(ctx, "d", []byte("d"), -time.Second)
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(2, store.client.Len(), "cleaner should be stopped")
+
+	assert.Equal(1, store.Clean())
+	assert.Equal(uint64(3), store.Reaped())
+	assert.Equal(0, (&MemoryStore{}).Clean())
+}
+
+func TestMemoryStoreClose(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	config := MemoryStoreConfig{
+		Size:          10,
+		SaveAs:        filepath.Join(t.TempDir(), "store"),
+		Interval:      time.Hour,
+		CleanInterval: time.Hour,
+	}
+	store, err := NewMemoryStoreByConfig(config)
+	assert.Nil(err, "new memory store fail")
+	value := []byte("abcd")
+	_ = store.Set(ctx, "a", value, time.Minute)
+
+	err = store.Close(ctx)
+	assert.Nil(err, "close should flush the snapshot")
+	assert.Nil(store.flushStop)
+	assert.Nil(store.cleanStop)
+	// close twice should be ok
+	err = store.Close(ctx)
+	assert.Nil(err)
+
+	store, err = NewMemoryStoreByConfig(config)
+	assert.Nil(err, "new memory store fail")
+	data, err := store.Get(ctx, "a")
+	assert.Nil(err)
+	assert.Equal(value, data, "the session should be restored after close")
+	store.StopFlush()
+	store.StopFlush()
+
+	store, err = NewMemoryStore(10)
+	assert.Nil(err)
+	assert.Nil(store.Close(ctx), "close store without snapshot should be ok")
+}
+
+func TestMemoryStorePersistenceError(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("restore", func(t *testing.T) {
+		assert := assert.New(t)
+		file := filepath.Join(t.TempDir(), "store")
+		err := os.WriteFile(file, []byte(`{"a":`), 0600)
+		assert.Nil(err)
+
+		_, err = NewMemoryStoreByConfig(MemoryStoreConfig{
+			Size:          10,
+			SaveAs:        file,
+			StrictRestore: true,
+		})
+		assert.Equal(ErrSnapshotInvalid, err, "strict restore should return error")
+
+		var restoreErr error
+		store, err := NewMemoryStoreByConfig(MemoryStoreConfig{
+			Size:   10,
+			SaveAs: file,
+			OnError: func(err error) {
+				restoreErr = err
+			},
+		})
+		assert.Nil(err)
+		assert.Equal(ErrSnapshotInvalid, restoreErr)
+		store.StopFlush()
+
+		// 文件不存在不认为出错
+		store, err = NewMemoryStoreByConfig(MemoryStoreConfig{
+			Size:          10,
+			SaveAs:        filepath.Join(t.TempDir(), "store"),
+			StrictRestore: true,
+		})
+		assert.Nil(err)
+		store.StopFlush()
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		assert := assert.New(t)
+		errs := make(chan error, 10)
+		store, err := NewMemoryStoreByConfig(MemoryStoreConfig{
+			Size:     10,
+			SaveAs:   filepath.Join(t.TempDir(), "not-exists", "store"),
+			Interval: time.Second,
+			OnError: func(err error) {
+				errs <- err
+			},
+		})
+		assert.Nil(err)
+		assert.True(store.LastFlushedAt().IsZero())
+		select {
+		case err = <-errs:
+			assert.NotNil(err, "interval flush should emit error")
+		case <-time.After(3 * time.Second):
+			assert.Fail("interval flush error should be emitted")
+		}
+		err = store.Close(ctx)
+		assert.NotNil(err, "close should return flush error")
+		assert.GreaterOrEqual(store.FlushFailures(), uint64(2))
+		assert.True(store.LastFlushedAt().IsZero())
+
+		store.saveAs = filepath.Join(t.TempDir(), "store")
+		err = store.Close(ctx)
+		assert.Nil(err)
+		assert.False(store.LastFlushedAt().IsZero())
+	})
+}
@@ -0,0 +1,163 @@
+// MIT License
+
+// Copyright (c) 2020 Tree Xie
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package session
+
+import (
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+// Metadata the metadata of session, it's stored with the data of session
+// under the reserved keys(_createdAt, _updatedAt, _expiredAt and _version)
+type Metadata struct {
+	// CreatedAt the created time of session
+	CreatedAt time.Time
+	// UpdatedAt the updated time of session, it's zero if the session isn't modified
+	UpdatedAt time.Time
+	// ExpiredAt the expired time of session, it's updated when written to store
+	ExpiredAt time.Time
+	// Version the version of session, it's increased when written to store
+	Version int64
+}
+
+// isReservedKey checks whether the key is reserved by session(metadata and flash),
+// the other keys start with "_" are not reserved for compatibility
+func isReservedKey(key string) bool {
+	switch key {
+	case CreatedAt, UpdatedAt, ExpiredAt, Version, FlashKey:
+		return true
+	}
+	return false
+}
+
+func newMetadata() Metadata {
+	return Metadata{
+		CreatedAt: time.Now(),
+	}
+}
+
+// parseTime parses the stored time, the time without sub-second(stored by old version) is supported
+func parseTime(value interface{}) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, cast.ToString(value))
+	return t
+}
+
+// formatTime formats the time for storing, the sub-second precision is kept
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// formatRFC3339 formats the time with second precision for the string getters
+func formatRFC3339(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// extractMetadata removes the metadata from data and returns it
+func extractMetadata(m M) Metadata {
+	md := Metadata{
+		CreatedAt: parseTime(m[CreatedAt]),
+		UpdatedAt: parseTime(m[UpdatedAt]),
+		ExpiredAt: parseTime(m[ExpiredAt]),
+		Version:   cast.ToInt64(toNumber(m[Version])),
+	}
+	delete(m, CreatedAt)
+	delete(m, UpdatedAt)
+	delete(m, ExpiredAt)
+	delete(m, Version)
+	return md
+}
+
+// activeAt returns the last active time of session,
+// it's the updated time or the created time if the session isn't updated
+func (md Metadata) activeAt() time.Time {
+	if md.UpdatedAt.IsZero() {
+		return md.CreatedAt
+	}
+	return md.UpdatedAt
+}
+
+// fill sets the metadata to data for storing, the zero value is ignored
+func (md *Metadata) fill(m M) {
+	for key, t := range map[string]time.Time{
+		CreatedAt: md.CreatedAt,
+		UpdatedAt: md.UpdatedAt,
+		ExpiredAt: md.ExpiredAt,
+	} {
+		if !t.IsZero() {
+			m[key] = formatTime(t)
+		}
+	}
+	if md.Version != 0 {
+		m[Version] = md.Version
+	}
+}
+
+// Metadata get the metadata of session, the error of fetching data is ignored like Get
+func (s *Session) Metadata() Metadata {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.fetch(s.context())
+	return s.meta
+}
+
+// CreatedAt get the created time of session
+func (s *Session) CreatedAt() time.Time {
+	return s.Metadata().CreatedAt
+}
+
+// UpdatedAt get the updated time of session, it's zero if the session isn't updated
+func (s *Session) UpdatedAt() time.Time {
+	return s.Metadata().UpdatedAt
+}
+
+// ExpiredAt get the expired time of session, it's zero if the session isn't stored
+func (s *Session) ExpiredAt() time.Time {
+	return s.Metadata().ExpiredAt
+}
+
+// Age returns the duration since the session was created,
+// it's zero if the created time is unknown
+func (s *Session) Age() time.Duration {
+	createdAt := s.CreatedAt()
+	if createdAt.IsZero() {
+		return 0
+	}
+	return time.Since(createdAt)
+}
+
+// IdleFor returns the duration since the last activity of session(updated time,
+// or created time if the session isn't updated), it's zero if the time is unknown
+func (s *Session) IdleFor() time.Duration {
+	activeAt := s.Metadata().activeAt()
+	if activeAt.IsZero() {
+		return 0
+	}
+	return time.Since(activeAt)
+}
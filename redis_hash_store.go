@@ -0,0 +1,157 @@
+// MIT License
+
+// Copyright (c) 2020 Tree Xie
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package session
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// setFieldsScript set and remove the fields of session, then extend the ttl.
+// The partial update(ARGV[1] is 1) is written only if the session exists,
+// ARGV[2] is the ttl, ARGV[3] is the count of fields, then the field and value pairs,
+// and the remaining are the removed fields
+var setFieldsScript = redis.NewScript(`
+if ARGV[1] == "1" and redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+local count = tonumber(ARGV[3])
+local index = 4
+for i = 1, count do
+	redis.call("HSET", KEYS[1], ARGV[index], ARGV[index + 1])
+	index = index + 2
+end
+for i = index, #ARGV do
+	redis.call("HDEL", KEYS[1], ARGV[i])
+end
+local ttl = tonumber(ARGV[2])
+if ttl > 0 then
+	redis.call("PEXPIRE", KEYS[1], ttl)
+end
+return 1
+`)
+
+var (
+	// ErrFieldsRequired the store should be read and written by fields
+	ErrFieldsRequired = createError("the store should be read and written by fields")
+)
+
+type (
+	// RedisHashStore redis store which stores the session as hash,
+	// each key of session is stored as a field, so only the changed fields
+	// are written when the session is committed(FieldStore)
+	RedisHashStore struct {
+		store *RedisStore
+	}
+)
+
+// Get the session data of hash store can't be got as a whole,
+// it returns ErrFieldsRequired, use GetFields instead
+func (hs *RedisHashStore) Get(_ context.Context, _ string) ([]byte, error) {
+	return nil, ErrFieldsRequired
+}
+
+// Set the session data of hash store can't be set as a whole,
+// it returns ErrFieldsRequired, use SetFields instead
+func (hs *RedisHashStore) Set(_ context.Context, _ string, _ []byte, _ time.Duration) error {
+	return ErrFieldsRequired
+}
+
+// GetFields get the fields of session from redis(HGETALL),
+// it returns nil if not exists
+func (hs *RedisHashStore) GetFields(ctx context.Context, key string) (fields map[string][]byte, err error) {
+	client := hs.store.client
+	if client == nil {
+		err = ErrNotInit
+		return
+	}
+	result, err := client.HGetAll(ctx, hs.store.getKey(key)).Result()
+	if err != nil || len(result) == 0 {
+		return
+	}
+	fields = make(map[string][]byte, len(result))
+	for k, v := range result {
+		fields[k] = []byte(v)
+	}
+	return
+}
+
+// SetFields set the changed fields(HSET), remove the deleted fields(HDEL)
+// and extend the ttl of session(PEXPIRE) by lua script. The partial update
+// of existing session is written only if the session exists, otherwise it
+// returns ErrConflict(such as the session is destroyed by other request)
+func (hs *RedisHashStore) SetFields(ctx context.Context, key string, fields map[string][]byte, removed []string, ttl time.Duration) (err error) {
+	client := hs.store.client
+	if client == nil {
+		err = ErrNotInit
+		return
+	}
+	partial := 0
+	if isPartialFields(fields) {
+		partial = 1
+	}
+	args := make([]interface{}, 0, 3+2*len(fields)+len(removed))
+	args = append(args, partial, ttl.Milliseconds(), len(fields))
+	for k, v := range fields {
+		args = append(args, k, v)
+	}
+	for _, k := range removed {
+		args = append(args, k)
+	}
+	result, err := setFieldsScript.Run(ctx, client, []string{
+		hs.store.getKey(key),
+	}, args...).Int()
+	if err != nil {
+		return
+	}
+	if result == 0 {
+		err = ErrConflict
+	}
+	return
+}
+
+// Touch extend the ttl of session(EXPIRE)
+func (hs *RedisHashStore) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	return hs.store.Touch(ctx, key, ttl)
+}
+
+// TTL get the remaining ttl of session(PTTL), it returns 0
+// if the session isn't exists or has no ttl
+func (hs *RedisHashStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return hs.store.TTL(ctx, key)
+}
+
+// Destroy remove the session from redis
+func (hs *RedisHashStore) Destroy(ctx context.Context, key string) error {
+	return hs.store.Destroy(ctx, key)
+}
+
+// NewRedisHashStore create new redis hash store instance,
+// the prefix will be added to the key of session
+func NewRedisHashStore(client redis.UniversalClient, prefix string) *RedisHashStore {
+	return &RedisHashStore{
+		store: NewRedisStore(client, prefix),
+	}
+}
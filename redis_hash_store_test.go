@@ -0,0 +1,218 @@
+// MIT License
+
+// Copyright (c) 2020 Tree Xie
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRedisHashStore(t *testing.T) (*miniredis.Miniredis, *RedisHashStore) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return mr, NewRedisHashStore(client, "ss:")
+}
+
+func TestRedisHashStore(t *testing.T) {
+	mr, hs := newTestRedisHashStore(t)
+	key := generateID()
+	ttl := 300 * time.Second
+	ctx := context.Background()
+
+	t.Run("not init", func(t *testing.T) {
+		assert := assert.New(t)
+		tmp := NewRedisHashStore(nil, "")
+		_, err := tmp.GetFields(ctx, key)
+		assert.Equal(ErrNotInit, err)
+
+		err = tmp.SetFields(ctx, key, nil, nil, ttl)
+		assert.Equal(ErrNotInit, err)
+
+		err = tmp.Destroy(ctx, key)
+		assert.Equal(ErrNotInit, err)
+	})
+
+	t.Run("get and set", func(t *testing.T) {
+		assert := assert.New(t)
+		_, err := hs.Get(ctx, key)
+		assert.Equal(ErrFieldsRequired, err)
+		err = hs.Set(ctx, key, []byte("tree.xie"), ttl)
+		assert.Equal(ErrFieldsRequired, err)
+	})
+
+	t.Run("set fields", func(t *testing.T) {
+		assert := assert.New(t)
+		fields, err := hs.GetFields(ctx, key)
+		assert.Nil(err)
+		assert.Nil(fields, "not exists data should be nil")
+
+		// the partial update of not exists session
+		err = hs.SetFields(ctx, key, map[string][]byte{
+			"a": []byte("1"),
+		}, nil, ttl)
+		assert.Equal(ErrConflict, err)
+		assert.False(mr.Exists("ss:" + key))
+
+		err = hs.SetFields(ctx, key, map[string][]byte{
+			CreatedAt: []byte("1"),
+			"a":       []byte("1"),
+			"b":       []byte("2"),
+		}, nil, ttl)
+		assert.Nil(err)
+		assert.Equal(ttl, mr.TTL("ss:"+key))
+		assert.Equal("1", mr.HGet("ss:"+key, "a"))
+
+		err = hs.SetFields(ctx, key, map[string][]byte{
+			"c": []byte("3"),
+		}, []string{
+			"a",
+		}, time.Hour)
+		assert.Nil(err)
+		assert.Equal(time.Hour, mr.TTL("ss:"+key))
+		fields, err = hs.GetFields(ctx, key)
+		assert.Nil(err)
+		assert.Equal(map[string][]byte{
+			CreatedAt: []byte("1"),
+			"b":       []byte("2"),
+			"c":       []byte("3"),
+		}, fields)
+	})
+
+	t.Run("touch", func(t *testing.T) {
+		assert := assert.New(t)
+		err := hs.Touch(ctx, key, 2*time.Hour)
+		assert.Nil(err)
+		remaining, err := hs.TTL(ctx, key)
+		assert.Nil(err)
+		assert.Equal(2*time.Hour, remaining)
+	})
+
+	t.Run("destroy", func(t *testing.T) {
+		assert := assert.New(t)
+		err := hs.Destroy(ctx, key)
+		assert.Nil(err)
+		fields, err := hs.GetFields(ctx, key)
+		assert.Nil(err)
+		assert.Nil(fields, "should return nil after destroy")
+	})
+
+	t.Run("session", func(t *testing.T) {
+		assert := assert.New(t)
+		for _, codec := range []Codec{
+			JSONCodec,
+			GobCodec,
+			MsgpackCodec,
+		} {
+			id := generateID()
+			s := &Session{
+				Store: hs,
+				ID:    id,
+				Codec: codec,
+			}
+			assert.Nil(s.SetMap(ctx, map[string]interface{}{
+				"a": "a",
+				"b": 1,
+			}))
+			assert.Nil(s.Commit(ctx, time.Minute))
+			assert.Equal(time.Minute, mr.TTL("ss:"+id))
+
+			// the field-level updates don't overwrite the other fields
+			s1 := &Session{
+				Store: hs,
+				ID:    id,
+				Codec: codec,
+			}
+			s2 := &Session{
+				Store: hs,
+				ID:    id,
+				Codec: codec,
+			}
+			_, _ = s1.Fetch(ctx)
+			_, _ = s2.Fetch(ctx)
+			assert.Nil(s1.Set(ctx, "a", nil))
+			assert.Nil(s2.Set(ctx, "c", true))
+			assert.Nil(s1.Commit(ctx, time.Minute))
+			assert.Nil(s2.Commit(ctx, time.Minute))
+
+			s = &Session{
+				Store: hs,
+				ID:    id,
+				Codec: codec,
+			}
+			data, err := s.Fetch(ctx)
+			assert.Nil(err)
+			assert.Nil(data["a"])
+			assert.Equal(1, s.GetInt("b"))
+			assert.True(s.GetBool("c"))
+			assert.False(s.CreatedAt().IsZero())
+
+			assert.Nil(s.Destroy(ctx))
+			assert.False(mr.Exists("ss:" + id))
+		}
+	})
+
+	t.Run("destroy or expire before commit", func(t *testing.T) {
+		assert := assert.New(t)
+		for _, remove := range []func(id string){
+			func(id string) {
+				s := &Session{
+					Store: hs,
+					ID:    id,
+				}
+				assert.Nil(s.Destroy(ctx))
+			},
+			func(id string) {
+				mr.FastForward(2 * time.Minute)
+			},
+		} {
+			id := generateID()
+			s := &Session{
+				Store: hs,
+				ID:    id,
+			}
+			assert.Nil(s.Set(ctx, "account", "tree"))
+			assert.Nil(s.Commit(ctx, time.Minute))
+
+			s = &Session{
+				Store: hs,
+				ID:    id,
+			}
+			_, _ = s.Fetch(ctx)
+			remove(id)
+			assert.Nil(s.Set(ctx, "c", 3))
+			err := s.Commit(ctx, time.Minute)
+			assert.Equal(ErrConflict, err)
+			assert.False(mr.Exists("ss:"+id), "the removed session should not be recreated")
+		}
+	})
+}
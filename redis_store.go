@@ -0,0 +1,190 @@
+// MIT License
+
+// Copyright (c) 2020 Tree Xie
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package session
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// compareAndSwapScript set the data if the current data is equal to old,
+// the empty old means the key should not exist
+var compareAndSwapScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if current == false then
+	current = ""
+end
+if current ~= ARGV[1] then
+	return 0
+end
+local ttl = tonumber(ARGV[3])
+if ttl > 0 then
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ttl)
+else
+	redis.call("SET", KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+type (
+	// RedisStore redis store for session
+	RedisStore struct {
+		client redis.UniversalClient
+		prefix string
+	}
+)
+
+func (rs *RedisStore) getKey(key string) string {
+	return rs.prefix + key
+}
+
+// Get get the session from redis, it returns nil if not exists
+func (rs *RedisStore) Get(ctx context.Context, key string) (data []byte, err error) {
+	client := rs.client
+	if client == nil {
+		err = ErrNotInit
+		return
+	}
+	data, err = client.Get(ctx, rs.getKey(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	return
+}
+
+// GetMulti get the sessions from redis by pipeline,
+// the result is in the same order as keys and nil means not exists
+func (rs *RedisStore) GetMulti(ctx context.Context, keys ...string) (result [][]byte, err error) {
+	client := rs.client
+	if client == nil {
+		err = ErrNotInit
+		return
+	}
+	if len(keys) == 0 {
+		return
+	}
+	cmds := make([]*redis.StringCmd, len(keys))
+	// 使用pipeline而非mget，避免cluster模式下的cross slot
+	_, err = client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for index, key := range keys {
+			cmds[index] = pipe.Get(ctx, rs.getKey(key))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return
+	}
+	err = nil
+	result = make([][]byte, len(keys))
+	for index, cmd := range cmds {
+		buf, e := cmd.Bytes()
+		if errors.Is(e, redis.Nil) {
+			continue
+		}
+		if e != nil {
+			return nil, e
+		}
+		result[index] = buf
+	}
+	return
+}
+
+// Set set the session to redis with ttl(SET EX)
+func (rs *RedisStore) Set(ctx context.Context, key string, data []byte, ttl time.Duration) (err error) {
+	client := rs.client
+	if client == nil {
+		err = ErrNotInit
+		return
+	}
+	return client.Set(ctx, rs.getKey(key), data, ttl).Err()
+}
+
+// CompareAndSwap set the session to redis if the current data is equal to old,
+// the old is nil means the session should not exist. It uses lua script
+// to make sure the operation is atomic.
+func (rs *RedisStore) CompareAndSwap(ctx context.Context, key string, old, data []byte, ttl time.Duration) (swapped bool, err error) {
+	client := rs.client
+	if client == nil {
+		err = ErrNotInit
+		return
+	}
+	result, err := compareAndSwapScript.Run(ctx, client, []string{
+		rs.getKey(key),
+	}, old, data, ttl.Milliseconds()).Int()
+	if err != nil {
+		return
+	}
+	swapped = result == 1
+	return
+}
+
+// Touch extend the ttl of session(EXPIRE)
+func (rs *RedisStore) Touch(ctx context.Context, key string, ttl time.Duration) (err error) {
+	client := rs.client
+	if client == nil {
+		err = ErrNotInit
+		return
+	}
+	return client.Expire(ctx, rs.getKey(key), ttl).Err()
+}
+
+// TTL get the remaining ttl of session(PTTL), it returns 0
+// if the session isn't exists or has no ttl
+func (rs *RedisStore) TTL(ctx context.Context, key string) (ttl time.Duration, err error) {
+	client := rs.client
+	if client == nil {
+		err = ErrNotInit
+		return
+	}
+	ttl, err = client.PTTL(ctx, rs.getKey(key)).Result()
+	if err != nil {
+		return
+	}
+	// 不存在(-2)或无过期时间(-1)
+	if ttl < 0 {
+		ttl = 0
+	}
+	return
+}
+
+// Destroy remove the session from redis
+func (rs *RedisStore) Destroy(ctx context.Context, key string) (err error) {
+	client := rs.client
+	if client == nil {
+		err = ErrNotInit
+		return
+	}
+	return client.Del(ctx, rs.getKey(key)).Err()
+}
+
+// NewRedisStore create new redis store instance,
+// the prefix will be added to the key of session
+func NewRedisStore(client redis.UniversalClient, prefix string) *RedisStore {
+	return &RedisStore{
+		client: client,
+		prefix: prefix,
+	}
+}
@@ -0,0 +1,167 @@
+// MIT License
+
+// Copyright (c) 2020 Tree Xie
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRedisStore(t *testing.T) (*miniredis.Miniredis, *RedisStore) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return mr, NewRedisStore(client, "ss:")
+}
+
+func TestRedisStore(t *testing.T) {
+	mr, rs := newTestRedisStore(t)
+	key := generateID()
+	data := []byte("tree.xie")
+	ttl := 300 * time.Second
+	ctx := context.Background()
+
+	t.Run("not init", func(t *testing.T) {
+		assert := assert.New(t)
+		tmp := &RedisStore{}
+		_, err := tmp.Get(ctx, key)
+		assert.Equal(err, ErrNotInit)
+
+		_, err = tmp.GetMulti(ctx, key)
+		assert.Equal(err, ErrNotInit)
+
+		err = tmp.Set(ctx, key, data, ttl)
+		assert.Equal(err, ErrNotInit)
+
+		err = tmp.Destroy(ctx, key)
+		assert.Equal(err, ErrNotInit)
+	})
+
+	t.Run("get not exists data", func(t *testing.T) {
+		assert := assert.New(t)
+		buf, err := rs.Get(ctx, key)
+		assert.Nil(err)
+		assert.Nil(buf, "not exists data should be nil")
+	})
+
+	t.Run("set data", func(t *testing.T) {
+		assert := assert.New(t)
+		err := rs.Set(ctx, key, data, ttl)
+		assert.Nil(err)
+		buf, err := rs.Get(ctx, key)
+		assert.Nil(err)
+		assert.Equal(data, buf, "the data isn't the same after set")
+
+		assert.True(mr.Exists("ss:"+key), "key should be added prefix")
+		assert.Equal(ttl, mr.TTL("ss:"+key))
+	})
+
+	t.Run("get multi", func(t *testing.T) {
+		assert := assert.New(t)
+		result, err := rs.GetMulti(ctx)
+		assert.Nil(err)
+		assert.Empty(result)
+
+		result, err = rs.GetMulti(ctx, key, generateID(), key)
+		assert.Nil(err)
+		assert.Equal([][]byte{
+			data,
+			nil,
+			data,
+		}, result)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		assert := assert.New(t)
+		tmpKey := generateID()
+		err := rs.Set(ctx, tmpKey, data, time.Second)
+		assert.Nil(err)
+		mr.FastForward(2 * time.Second)
+		buf, err := rs.Get(ctx, tmpKey)
+		assert.Nil(err)
+		assert.Nil(buf, "expired data should be nil")
+	})
+
+	t.Run("touch", func(t *testing.T) {
+		assert := assert.New(t)
+		err := rs.Touch(ctx, key, time.Hour)
+		assert.Nil(err)
+		assert.Equal(time.Hour, mr.TTL("ss:"+key))
+		assert.Equal(ErrNotInit, (&RedisStore{}).Touch(ctx, key, time.Hour))
+
+		ttl, err := rs.TTL(ctx, key)
+		assert.Nil(err)
+		assert.Equal(time.Hour, ttl)
+		ttl, err = rs.TTL(ctx, generateID())
+		assert.Nil(err)
+		assert.Equal(time.Duration(0), ttl)
+		_, err = (&RedisStore{}).TTL(ctx, key)
+		assert.Equal(ErrNotInit, err)
+	})
+
+	t.Run("compare and swap", func(t *testing.T) {
+		assert := assert.New(t)
+		tmpKey := generateID()
+		// the session should not exist
+		swapped, err := rs.CompareAndSwap(ctx, tmpKey, nil, data, ttl)
+		assert.Nil(err)
+		assert.True(swapped)
+		assert.Equal(ttl, mr.TTL("ss:"+tmpKey))
+		swapped, err = rs.CompareAndSwap(ctx, tmpKey, nil, []byte("abc"), ttl)
+		assert.Nil(err)
+		assert.False(swapped, "the session exists, it should not be swapped")
+
+		swapped, err = rs.CompareAndSwap(ctx, tmpKey, data, []byte("abc"), ttl)
+		assert.Nil(err)
+		assert.True(swapped)
+		buf, _ := rs.Get(ctx, tmpKey)
+		assert.Equal([]byte("abc"), buf)
+
+		swapped, err = rs.CompareAndSwap(ctx, tmpKey, data, []byte("def"), ttl)
+		assert.Nil(err)
+		assert.False(swapped, "the data is changed, it should not be swapped")
+		buf, _ = rs.Get(ctx, tmpKey)
+		assert.Equal([]byte("abc"), buf)
+
+		_, err = (&RedisStore{}).CompareAndSwap(ctx, tmpKey, nil, data, ttl)
+		assert.Equal(ErrNotInit, err)
+	})
+
+	t.Run("destroy", func(t *testing.T) {
+		assert := assert.New(t)
+		err := rs.Destroy(ctx, key)
+		assert.Nil(err)
+		buf, err := rs.Get(ctx, key)
+		assert.Nil(err)
+		assert.Nil(buf, "should return nil after destroy")
+	})
+}
@@ -26,6 +26,8 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/spf13/cast"
@@ -34,16 +36,22 @@ import (
 )
 
 const (
-	// CreatedAt the created time of session
+	// CreatedAt the key of created time, which is used for storing metadata
 	CreatedAt = "_createdAt"
-	// UpdatedAt the updated time of session
+	// UpdatedAt the key of updated time, which is used for storing metadata
 	UpdatedAt = "_updatedAt"
-	// ExpiredAt the expired time of session
+	// ExpiredAt the key of expired time, which is used for storing metadata
 	ExpiredAt = "_expiredAt"
+	// FlashKey the key of flash messages
+	FlashKey = "_flash"
+	// Version the key of version, which is used for storing metadata
+	Version = "_version"
 	// ErrCategory session error category
 	ErrCategory = "elton-session"
 	// Key session key
 	Key = "_session"
+	// defaultConflictRetry the default retry times of merging the changed keys when the commit is conflict
+	defaultConflictRetry = 3
 )
 
 var (
@@ -53,6 +61,17 @@ var (
 	ErrIDNil = createError("session id is nil")
 	// ErrIsReadonly session is readonly
 	ErrIsReadonly = createError("session is readonly")
+	// ErrGenIDNil gen id function is nil
+	ErrGenIDNil = createError("gen id function is nil")
+	// ErrReservedKey the key is reserved for the metadata of session
+	ErrReservedKey = createError("the key is reserved")
+	// ErrConflict the session has been modified by other request
+	ErrConflict = &hes.Error{
+		Message:    "session conflict",
+		Category:   ErrCategory,
+		StatusCode: http.StatusConflict,
+		Exception:  true,
+	}
 )
 
 type (
@@ -70,9 +89,28 @@ type (
 		Expired time.Duration
 		// GenID generate uid
 		GenID func() string
+		// Rolling extend the session's ttl and reissue the session id
+		// to client on each request
+		Rolling bool
+		// RollingInterval the min interval of rolling,
+		// it's measured from the last time the session was written to store
+		RollingInterval time.Duration
+		// IdleTimeout the session is treated as a new one if there is no activity for the duration,
+		// it requires Rolling to record the activity of each request
+		IdleTimeout time.Duration
+		// MaxLifetime the max lifetime of session since created, regardless of activity
+		MaxLifetime time.Duration
+		// Codec the codec of session data, default is JSONCodec
+		Codec Codec
+		// ConflictRetry the max retry times of merging the changed keys
+		// when the commit is conflict, it works only if the store implements Swapper,
+		// default is 3, set it to a negative value to return ErrConflict without merging
+		ConflictRetry int
 
 		Get func(c *elton.Context) (string, error)
 		Set func(c *elton.Context, id string) error
+		// Clear clear the session id of client, it will be called after session is destroyed
+		Clear func(c *elton.Context) error
 	}
 	// CookieConfig session cookie config
 	CookieConfig struct {
@@ -84,6 +122,21 @@ type (
 		Expired time.Duration
 		// GenID generate uid
 		GenID func() string
+		// Rolling extend the session's ttl and reissue the session id
+		// to client on each request
+		Rolling bool
+		// RollingInterval the min interval of rolling
+		RollingInterval time.Duration
+		// IdleTimeout the session is treated as a new one if there is no activity for the duration,
+		// it requires Rolling to record the activity of each request
+		IdleTimeout time.Duration
+		// MaxLifetime the max lifetime of session since created, regardless of activity
+		MaxLifetime time.Duration
+		// Codec the codec of session data, default is JSONCodec
+		Codec Codec
+		// ConflictRetry the max retry times of merging the changed keys when the commit is conflict,
+		// default is 3, set it to a negative value to return ErrConflict without merging
+		ConflictRetry int
 
 		// Signed signed cookie
 		Signed bool
@@ -105,26 +158,71 @@ type (
 		Expired time.Duration
 		// GenID generate uid
 		GenID func() string
+		// Rolling extend the session's ttl and reissue the session id
+		// to client on each request
+		Rolling bool
+		// RollingInterval the min interval of rolling
+		RollingInterval time.Duration
+		// IdleTimeout the session is treated as a new one if there is no activity for the duration,
+		// it requires Rolling to record the activity of each request
+		IdleTimeout time.Duration
+		// MaxLifetime the max lifetime of session since created, regardless of activity
+		MaxLifetime time.Duration
+		// Codec the codec of session data, default is JSONCodec
+		Codec Codec
+		// ConflictRetry the max retry times of merging the changed keys when the commit is conflict,
+		// default is 3, set it to a negative value to return ErrConflict without merging
+		ConflictRetry int
 
 		// Name header's name
 		Name string
 	}
-	// Session session struct
+	// Session session struct, it's safe for concurrent use
 	Session struct {
+		// mu protects the session for concurrent use
+		mu sync.Mutex
 		// Store session store
 		Store Store
 		// ID the session id
 		ID string
-		// the data fetch from session
+		// GenID generate session id, it's used for regenerating
+		GenID func() string
+		// IdleTimeout the session is treated as a new one if there is no activity for the duration,
+		// it requires Rolling to record the activity of each request
+		IdleTimeout time.Duration
+		// MaxLifetime the session is treated as a new one if it's created before the duration,
+		// regardless of activity
+		MaxLifetime time.Duration
+		// Codec the codec of session data, default is JSONCodec
+		Codec Codec
+		// ConflictRetry the max retry times of merging the changed keys
+		// when the commit is conflict, it returns ErrConflict if exceeds,
+		// default is 3, set it to a negative value to return ErrConflict without merging
+		ConflictRetry int
+		// the context of request, it's used for fetching data by getters
+		ctx context.Context
+		// the data fetch from session(without metadata)
 		data M
+		// the metadata of session
+		meta Metadata
+		// the raw data of store, it's used for compare and swap
+		raw []byte
+		// the keys which are set or deleted
+		changes map[string]struct{}
 		// the data has been fetched
 		fetched bool
+		// the data is loaded from store
+		loaded bool
 		// the data has been modified
 		modified bool
 		// the session has been committed
 		committed bool
 		// the session is readonly
 		readonly bool
+		// the session id has been changed
+		idChanged bool
+		// the session has been destroyed
+		destroyed bool
 	}
 	// Store session store
 	Store interface {
@@ -135,6 +233,34 @@ type (
 		// Destroy remove the session data
 		Destroy(context.Context, string) error
 	}
+	// Swapper the optional interface of store, which supports compare and swap.
+	// The commit of session uses it to detect the conflict of concurrent requests
+	Swapper interface {
+		// CompareAndSwap set the session data if the current data is equal to old,
+		// the old is nil means the session should not exist
+		CompareAndSwap(ctx context.Context, key string, old, data []byte, ttl time.Duration) (bool, error)
+	}
+	// FieldStore the optional interface of store, which supports field-level updates(such as redis hash).
+	// The value of field is encoded by the codec of session, and only the changed fields
+	// are written when the session is committed.
+	FieldStore interface {
+		// GetFields get the fields of session
+		GetFields(ctx context.Context, key string) (map[string][]byte, error)
+		// SetFields set the changed fields, remove the deleted fields and extend the ttl of session.
+		// The fields without created time are the partial update of existing session,
+		// which should be written only if the session exists, otherwise return ErrConflict
+		SetFields(ctx context.Context, key string, fields map[string][]byte, removed []string, ttl time.Duration) error
+	}
+	// Toucher the optional interface of store,
+	// which extends the ttl of session without data
+	Toucher interface {
+		// Touch extend the ttl of session, it does nothing if the session isn't exists
+		Touch(context.Context, string, time.Duration) error
+		// TTL get the remaining ttl of session, it's used for the interval of rolling
+		// as touch doesn't change the data. It returns 0 if the ttl is unknown(such as
+		// the session isn't exists or the store doesn't support)
+		TTL(context.Context, string) (time.Duration, error)
+	}
 )
 
 func createError(message string) *hes.Error {
@@ -157,69 +283,196 @@ func wrapError(err error) *hes.Error {
 	return he
 }
 
-func initMap() M {
-	m := make(M)
-	m[CreatedAt] = time.Now().Format(time.RFC3339)
-	return m
+func (s *Session) codec() Codec {
+	if s.Codec == nil {
+		return JSONCodec
+	}
+	return s.Codec
 }
 
 func (s *Session) fetch(ctx context.Context) error {
 	if s.fetched {
 		return nil
 	}
-	store := s.Store
+	var m M
 	var buf []byte
 	if s.ID != "" {
-		b, err := store.Get(ctx, s.ID)
+		var err error
+		m, buf, err = s.load(ctx)
 		if err != nil {
 			return err
 		}
-		buf = b
 	}
-	m := make(M)
-	if len(buf) == 0 {
-		m = initMap()
-	} else {
-		err := json.Unmarshal(buf, &m)
+	var meta Metadata
+	if m != nil {
+		meta = extractMetadata(m)
+	}
+	// 超时的session删除，作为新的session
+	if m != nil && s.isTimeout(meta) {
+		err := s.Store.Destroy(ctx, s.ID)
 		if err != nil {
 			return err
 		}
+		s.ID = ""
+		s.destroyed = true
+		m = nil
+		buf = nil
 	}
 	s.fetched = true
+	s.loaded = m != nil
+	if m == nil {
+		m = make(M)
+		meta = newMetadata()
+	}
+	s.raw = buf
 	s.data = m
+	s.meta = meta
 	return nil
 }
 
-// Fetch fetch the session data from store
-func (s *Session) Fetch(ctx context.Context) (m M, err error) {
-	if s.fetched {
-		return s.data, nil
+// load gets the data(with metadata) of session from store, it returns nil if the session isn't exists.
+// The raw data is returned for compare and swap, it's nil for field store.
+func (s *Session) load(ctx context.Context) (M, []byte, error) {
+	if store, ok := s.Store.(FieldStore); ok {
+		fields, err := store.GetFields(ctx, s.ID)
+		if err != nil || len(fields) == 0 {
+			return nil, nil, err
+		}
+		m := make(M, len(fields))
+		for k, buf := range fields {
+			var v interface{}
+			err = s.codec().Unmarshal(buf, &v)
+			if err != nil {
+				return nil, nil, err
+			}
+			m[k] = v
+		}
+		return m, nil, nil
+	}
+	buf, err := s.Store.Get(ctx, s.ID)
+	if err != nil || len(buf) == 0 {
+		return nil, nil, err
+	}
+	m := make(M)
+	err = s.codec().Unmarshal(buf, &m)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, buf, nil
+}
+
+// isTimeout checks whether the session is idle timeout or reaches the max lifetime
+func (s *Session) isTimeout(meta Metadata) bool {
+	if s.IdleTimeout <= 0 && s.MaxLifetime <= 0 {
+		return false
+	}
+	now := time.Now()
+	createdAt := meta.CreatedAt
+	if s.MaxLifetime > 0 && !createdAt.IsZero() &&
+		now.Sub(createdAt) > s.MaxLifetime {
+		return true
+	}
+	activeAt := meta.activeAt()
+	if s.IdleTimeout > 0 && !activeAt.IsZero() &&
+		now.Sub(activeAt) > s.IdleTimeout {
+		return true
+	}
+	return false
+}
+
+// copyData returns the copy of session data
+func (s *Session) copyData() M {
+	if s.data == nil {
+		return nil
+	}
+	m := make(M, len(s.data))
+	for k, v := range s.data {
+		m[k] = v
+	}
+	return m
+}
+
+// storeData returns the copy of session data with metadata for storing
+func (s *Session) storeData() M {
+	m := s.copyData()
+	if m == nil {
+		m = make(M)
 	}
+	s.meta.fill(m)
+	return m
+}
+
+// Fetch fetch the session data from store,
+// it returns the copy of session data
+func (s *Session) Fetch(ctx context.Context) (m M, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	err = s.fetch(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return s.data, nil
+	return s.copyData(), nil
 }
 
 // Destroy remove the data from store and reset session data
 func (s *Session) Destroy(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.ID == "" {
 		return nil
 	}
 	store := s.Store
-	m := initMap()
-	s.data = m
+	s.data = make(M)
+	s.meta = newMetadata()
 	err := store.Destroy(ctx, s.ID)
 	if err != nil {
 		return err
 	}
 	s.ID = ""
+	s.raw = nil
+	s.changes = nil
+	s.loaded = false
+	// 数据已清除，无需再提交
+	s.modified = false
+	s.destroyed = true
+	return nil
+}
+
+// Regenerate regenerate the session id and keep the data,
+// the data of old session id will be removed from store.
+// It should be called after login to prevent session fixation.
+func (s *Session) Regenerate(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.readonly {
+		return ErrIsReadonly
+	}
+	if s.GenID == nil {
+		return ErrGenIDNil
+	}
+	err := s.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	if s.ID != "" {
+		err = s.Store.Destroy(ctx, s.ID)
+		if err != nil {
+			return err
+		}
+	}
+	s.ID = s.GenID()
+	s.raw = nil
+	// 新的session id需要写入全部数据
+	s.loaded = false
+	s.idChanged = true
+	// 新的session id需要重新提交
+	s.committed = false
+	s.updatedAt()
 	return nil
 }
 
 func (s *Session) updatedAt() {
-	s.data[UpdatedAt] = time.Now().Format(time.RFC3339)
+	s.meta.UpdatedAt = time.Now()
 	s.modified = true
 }
 
@@ -233,8 +486,20 @@ func (s *Session) Set(ctx context.Context, key string, value interface{}) error
 	})
 }
 
-// SetMap set map data to session
+// SetMap set map data to session, the nil value means deleting the key.
+// It returns ErrReservedKey if the key is reserved(metadata and flash)
 func (s *Session) SetMap(ctx context.Context, value map[string]interface{}) error {
+	for key := range value {
+		if isReservedKey(key) {
+			return ErrReservedKey
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.setMap(ctx, value)
+}
+
+func (s *Session) setMap(ctx context.Context, value map[string]interface{}) error {
 	if s.readonly {
 		return ErrIsReadonly
 	}
@@ -245,7 +510,11 @@ func (s *Session) SetMap(ctx context.Context, value map[string]interface{}) erro
 	if err != nil {
 		return err
 	}
+	if s.changes == nil {
+		s.changes = make(map[string]struct{})
+	}
 	for k, v := range value {
+		s.changes[k] = struct{}{}
 		if v == nil {
 			delete(s.data, k)
 			continue
@@ -257,18 +526,112 @@ func (s *Session) SetMap(ctx context.Context, value map[string]interface{}) erro
 	return nil
 }
 
+// Delete delete the keys from session, the session is modified only if the key exists
+func (s *Session) Delete(ctx context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.readonly {
+		return ErrIsReadonly
+	}
+	for _, key := range keys {
+		if isReservedKey(key) {
+			return ErrReservedKey
+		}
+	}
+	err := s.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	value := make(map[string]interface{})
+	for _, key := range keys {
+		if _, ok := s.data[key]; ok {
+			value[key] = nil
+		}
+	}
+	if len(value) == 0 {
+		return nil
+	}
+	return s.setMap(ctx, value)
+}
+
+// Clear delete all data of session, the metadata(such as created at) is kept,
+// the session is modified only if there is data to delete
+func (s *Session) Clear(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.readonly {
+		return ErrIsReadonly
+	}
+	err := s.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	value := make(map[string]interface{})
+	for key := range s.data {
+		value[key] = nil
+	}
+	if len(value) == 0 {
+		return nil
+	}
+	return s.setMap(ctx, value)
+}
+
+// Has checks whether the key exists, it returns false for the reserved keys(such as flash)
+// like Keys, the error of fetching data is ignored like Get
+func (s *Session) Has(key string) bool {
+	if isReservedKey(key) {
+		return false
+	}
+	_, ok, _ := s.Lookup(s.context(), key)
+	return ok
+}
+
+// Keys returns the sorted keys of session except the reserved keys(such as flash),
+// the error of fetching data is ignored like Get
+func (s *Session) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.fetch(s.context())
+	keys := make([]string, 0, len(s.data))
+	for key := range s.data {
+		if !isReservedKey(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ChangedKeys returns the sorted keys which are set or deleted
+func (s *Session) ChangedKeys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.changes))
+	for k := range s.changes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // Readonly
 func (s *Session) Readonly() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return s.readonly
 }
 
 // EnableReadonly enable session readonly
 func (s *Session) EnableReadonly() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.readonly = true
 }
 
 // Refresh refresh session (update updatedAt)
 func (s *Session) Refresh(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	err := s.fetch(ctx)
 	if err != nil {
 		return err
@@ -277,10 +640,48 @@ func (s *Session) Refresh(ctx context.Context) error {
 	return nil
 }
 
-// Get get data from session's data
+func (s *Session) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// Lookup get data from session's data, it returns the error of fetching data
+// and whether the key exists
+func (s *Session) Lookup(ctx context.Context, key string) (interface{}, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.fetch(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	value, ok := s.data[key]
+	return value, ok, nil
+}
+
+// Get get data from session's data, the error of fetching data is ignored
+// and the request context is used(if the session is created by middleware),
+// use Lookup if the error should be handled
 func (s *Session) Get(key string) interface{} {
-	_ = s.fetch(context.Background())
-	return s.data[key]
+	value, _, _ := s.Lookup(s.context(), key)
+	return value
+}
+
+// toNumber converts json.Number to int64 or float64,
+// the json codec decodes numbers as json.Number to preserve precision
+func toNumber(value interface{}) interface{} {
+	n, ok := value.(json.Number)
+	if !ok {
+		return value
+	}
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	if f, err := n.Float64(); err == nil {
+		return f
+	}
+	return value
 }
 
 // GetBool get bool data from session's data
@@ -295,12 +696,17 @@ func (s *Session) GetString(key string) string {
 
 // GetInt get int data from session's data
 func (s *Session) GetInt(key string) int {
-	return cast.ToInt(s.Get(key))
+	return cast.ToInt(toNumber(s.Get(key)))
+}
+
+// GetInt64 get int64 data from session's data
+func (s *Session) GetInt64(key string) int64 {
+	return cast.ToInt64(toNumber(s.Get(key)))
 }
 
 // GetFloat64 get float64 data from session's data
 func (s *Session) GetFloat64(key string) float64 {
-	return cast.ToFloat64(s.Get(key))
+	return cast.ToFloat64(toNumber(s.Get(key)))
 }
 
 // GetStringSlice get string slice data from session's data
@@ -308,28 +714,35 @@ func (s *Session) GetStringSlice(key string) []string {
 	return cast.ToStringSlice(s.Get(key))
 }
 
-// GetCreatedAt get the created time of session
+// GetCreatedAt get the created time of session(RFC3339),
+// use CreatedAt to get the time.Time with sub-second precision
 func (s *Session) GetCreatedAt() string {
-	return cast.ToString(s.Get(CreatedAt))
+	return formatRFC3339(s.CreatedAt())
 }
 
-// GetUpdatedAt get the updated time of session
+// GetUpdatedAt get the updated time of session(RFC3339),
+// use UpdatedAt to get the time.Time with sub-second precision
 func (s *Session) GetUpdatedAt() string {
-	return cast.ToString(s.Get(UpdatedAt))
+	return formatRFC3339(s.UpdatedAt())
 }
 
-// GetExpiredAt get the expired time of session
+// GetExpiredAt get the expired time of session(RFC3339),
+// use ExpiredAt to get the time.Time with sub-second precision
 func (s *Session) GetExpiredAt() string {
-	return cast.ToString(s.Get(ExpiredAt))
+	return formatRFC3339(s.ExpiredAt())
 }
 
-// GetData get the session's data
+// GetData get the copy of session's data
 func (s *Session) GetData() M {
-	return s.data
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.copyData()
 }
 
 // Commit sync the session to store
 func (s *Session) Commit(ctx context.Context, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if !s.modified {
 		return nil
 	}
@@ -340,22 +753,196 @@ func (s *Session) Commit(ctx context.Context, ttl time.Duration) error {
 	if s.ID == "" {
 		return ErrIDNil
 	}
-	// 写入store时更新expired at
-	s.data[ExpiredAt] = time.Now().Add(ttl).Format(time.RFC3339)
+	retry := s.ConflictRetry
+	if retry == 0 {
+		retry = defaultConflictRetry
+	}
+	for i := 0; ; i++ {
+		swapped, err := s.save(ctx, ttl)
+		if err != nil {
+			return err
+		}
+		if swapped {
+			break
+		}
+		// 数据已被其它请求修改
+		if i >= retry {
+			return ErrConflict
+		}
+		err = s.merge(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	s.committed = true
+	return nil
+}
+
+// save writes the session data to store, the version of session is increased.
+// If the store implements FieldStore, only the changed fields are written.
+// If the store implements Swapper, the data is written only if the data of store
+// isn't changed since fetched(the session is loaded from store), otherwise it returns false.
+func (s *Session) save(ctx context.Context, ttl time.Duration) (bool, error) {
+	// 写入store时更新expired at与版本
+	s.meta.ExpiredAt = time.Now().Add(ttl)
+	s.meta.Version++
 
-	buf, err := json.Marshal(s.data)
+	if store, ok := s.Store.(FieldStore); ok {
+		err := s.saveFields(ctx, store, ttl)
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	buf, err := s.codec().Marshal(s.storeData())
+	if err != nil {
+		return false, err
+	}
+	swapper, ok := s.Store.(Swapper)
+	// 仅当session从store中加载时才需要校验是否被其它请求修改
+	if !ok || s.raw == nil {
+		err = s.Store.Set(ctx, s.ID, buf, ttl)
+		if err != nil {
+			return false, err
+		}
+		s.raw = buf
+		return true, nil
+	}
+	swapped, err := swapper.CompareAndSwap(ctx, s.ID, s.raw, buf, ttl)
+	if err != nil || !swapped {
+		return false, err
+	}
+	s.raw = buf
+	return true, nil
+}
+
+// saveFields writes the changed fields and the metadata of session to field store,
+// all fields are written if the session isn't loaded from store.
+// isPartialFields checks whether the fields are the partial update of existing session,
+// all fields(with created time) are written for the new session
+func isPartialFields(fields map[string][]byte) bool {
+	_, ok := fields[CreatedAt]
+	return !ok
+}
+
+func (s *Session) saveFields(ctx context.Context, store FieldStore, ttl time.Duration) error {
+	data := s.storeData()
+	var keys []string
+	if s.loaded {
+		keys = []string{
+			UpdatedAt,
+			ExpiredAt,
+			Version,
+		}
+		for k := range s.changes {
+			keys = append(keys, k)
+		}
+	} else {
+		for k := range data {
+			keys = append(keys, k)
+		}
+	}
+	fields := make(map[string][]byte, len(keys))
+	var removed []string
+	for _, k := range keys {
+		v, ok := data[k]
+		if !ok {
+			removed = append(removed, k)
+			continue
+		}
+		buf, err := s.codec().Marshal(&v)
+		if err != nil {
+			return err
+		}
+		fields[k] = buf
+	}
+	err := store.SetFields(ctx, s.ID, fields, removed, ttl)
 	if err != nil {
 		return err
 	}
+	s.loaded = true
+	return nil
+}
 
-	err = s.Store.Set(ctx, s.ID, buf, ttl)
+// merge applies the changed keys to the latest data of store,
+// it returns ErrConflict if the session has been removed(such as logout by other request)
+func (s *Session) merge(ctx context.Context) error {
+	m, buf, err := s.load(ctx)
 	if err != nil {
 		return err
 	}
-	s.committed = true
+	// session已被其它请求删除，不能再写入
+	if m == nil {
+		return ErrConflict
+	}
+	meta := extractMetadata(m)
+	for k := range s.changes {
+		v, ok := s.data[k]
+		if !ok {
+			delete(m, k)
+			continue
+		}
+		m[k] = v
+	}
+	meta.UpdatedAt = s.meta.UpdatedAt
+	s.raw = buf
+	s.data = m
+	s.meta = meta
 	return nil
 }
 
+// roll extends the ttl of session which is not modified,
+// it returns false if the session isn't stored or the interval isn't reached.
+// If the store implements Toucher, it uses Touch to extend the ttl without data,
+// otherwise the updated time is refreshed as the activity of session(for idle timeout)
+// and the data is written to store, but the session isn't marked as modified.
+func (s *Session) roll(ctx context.Context, ttl, interval time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ID == "" || s.modified || s.committed {
+		return false, nil
+	}
+	err := s.fetch(ctx)
+	if err != nil {
+		return false, err
+	}
+	// 未保存至store的session（如session id无效）
+	if !s.loaded {
+		return false, nil
+	}
+	// idle timeout需要根据updated at判断，因此需要写入数据
+	toucher, ok := s.Store.(Toucher)
+	if ok && s.IdleTimeout <= 0 {
+		remaining, err := toucher.TTL(ctx, s.ID)
+		if err != nil {
+			return false, err
+		}
+		// touch不更新数据，因此根据store的剩余ttl判断上次rolling的时间，
+		// 如果ttl未知则写入数据
+		if remaining > 0 {
+			if interval > 0 && ttl-remaining < interval {
+				return false, nil
+			}
+			err = toucher.Touch(ctx, s.ID, ttl)
+			if err != nil {
+				return false, err
+			}
+			return true, nil
+		}
+	}
+	// 根据上次写入store的时间判断是否需要rolling
+	expiredAt := s.meta.ExpiredAt
+	if !expiredAt.IsZero() && interval > 0 {
+		if time.Since(expiredAt.Add(-ttl)) < interval {
+			return false, nil
+		}
+	}
+	s.meta.UpdatedAt = time.Now()
+	// 如果冲突，则表示已被其它请求更新，无需再rolling
+	return s.save(ctx, ttl)
+}
+
 // Get gets the session data from context
 func Get(c *elton.Context) (*Session, bool) {
 	value, ok := c.Get(Key)
@@ -384,6 +971,7 @@ func New(config Config) elton.Handler {
 	store := config.Store
 	getID := config.Get
 	setID := config.Set
+	clearID := config.Clear
 	genID := config.GenID
 	expired := config.Expired
 	if store == nil ||
@@ -393,10 +981,23 @@ func New(config Config) elton.Handler {
 		expired == 0 {
 		panic("require store, get function, set function and expired")
 	}
+	// idle timeout根据最后活跃时间判断，需要rolling记录每次请求的活跃时间
+	if config.IdleTimeout > 0 {
+		if !config.Rolling {
+			panic("require rolling for idle timeout")
+		}
+		if config.RollingInterval >= config.IdleTimeout {
+			panic("rolling interval should be less than idle timeout")
+		}
+	}
 	skipper := config.Skipper
 	if skipper == nil {
 		skipper = elton.DefaultSkipper
 	}
+	rolling := config.Rolling
+	rollingInterval := config.RollingInterval
+	// cookie store需要通过context读写cookie(包括被包装的cookie store)
+	eltonContextRequired := requireEltonContext(store)
 	return func(c *elton.Context) error {
 		if skipper(c) {
 			return c.Next()
@@ -405,8 +1006,17 @@ func New(config Config) elton.Handler {
 		if exists {
 			return c.Next()
 		}
+		if eltonContextRequired {
+			withEltonContext(c)
+		}
 		s := &Session{
-			Store: store,
+			ctx:           c.Context(),
+			Store:         store,
+			GenID:         genID,
+			IdleTimeout:   config.IdleTimeout,
+			MaxLifetime:   config.MaxLifetime,
+			Codec:         config.Codec,
+			ConflictRetry: config.ConflictRetry,
 		}
 		id, err := getID(c)
 		if err != nil {
@@ -417,7 +1027,7 @@ func New(config Config) elton.Handler {
 		}
 		// 拉取session（默认都拉取，未做动态拉取）
 		if !config.LazyFetch {
-			err = s.fetch(c.Context())
+			_, err = s.Fetch(c.Context())
 			if err != nil {
 				return wrapError(err)
 			}
@@ -430,21 +1040,51 @@ func New(config Config) elton.Handler {
 		if err != nil {
 			return err
 		}
-		if s.modified {
-			// 如果session 有修改而且未生成session id
-			if s.ID == "" {
-				uid := genID()
-				err = setID(c, uid)
+		// handler中可能有未结束的goroutine，因此加锁读取状态
+		s.mu.Lock()
+		// 如果session 有修改而且未生成session id
+		if s.modified && s.ID == "" {
+			s.ID = genID()
+			s.idChanged = true
+		}
+		modified := s.modified
+		destroyed := s.destroyed
+		idChanged := s.idChanged
+		sessionID := s.ID
+		s.mu.Unlock()
+		if modified {
+			// session id有变化（新生成或重新生成）或rolling则设置
+			if idChanged || rolling {
+				err = setID(c, sessionID)
 				if err != nil {
 					return wrapError(err)
 				}
-				s.ID = uid
 			}
 			// 提交session 数据
 			err = s.Commit(c.Context(), expired)
 			if err != nil {
 				return wrapError(err)
 			}
+		} else if destroyed {
+			// session 已销毁则清除客户端的session id
+			if clearID != nil {
+				err = clearID(c)
+				if err != nil {
+					return wrapError(err)
+				}
+			}
+		} else if rolling {
+			// 未修改的session延长有效期
+			rolled, err := s.roll(c.Context(), expired, rollingInterval)
+			if err != nil {
+				return wrapError(err)
+			}
+			if rolled {
+				err = setID(c, sessionID)
+				if err != nil {
+					return wrapError(err)
+				}
+			}
 		}
 		return nil
 	}
@@ -467,7 +1107,7 @@ func NewByCookie(config CookieConfig) elton.Handler {
 		}
 		return cookie.Value, nil
 	}
-	setID := func(c *elton.Context, id string) error {
+	addCookie := func(c *elton.Context, id string, maxAge int) {
 		setCookie := c.AddCookie
 		if config.Signed {
 			setCookie = c.AddSignedCookie
@@ -479,19 +1119,34 @@ func NewByCookie(config CookieConfig) elton.Handler {
 			Value:    id,
 			Path:     config.Path,
 			Domain:   config.Domain,
-			MaxAge:   config.MaxAge,
+			MaxAge:   maxAge,
 			Secure:   config.Secure,
 			HttpOnly: config.HttpOnly,
 		})
+	}
+	setID := func(c *elton.Context, id string) error {
+		addCookie(c, id, config.MaxAge)
+		return nil
+	}
+	clearID := func(c *elton.Context) error {
+		// 设置max age为-1，删除cookie
+		addCookie(c, "", -1)
 		return nil
 	}
 
 	return New(Config{
-		Store:   config.Store,
-		Get:     getID,
-		Set:     setID,
-		GenID:   config.GenID,
-		Expired: config.Expired,
+		Store:           config.Store,
+		Get:             getID,
+		Set:             setID,
+		Clear:           clearID,
+		GenID:           config.GenID,
+		Expired:         config.Expired,
+		Rolling:         config.Rolling,
+		RollingInterval: config.RollingInterval,
+		IdleTimeout:     config.IdleTimeout,
+		MaxLifetime:     config.MaxLifetime,
+		Codec:           config.Codec,
+		ConflictRetry:   config.ConflictRetry,
 	})
 }
 
@@ -510,11 +1165,24 @@ func NewByHeader(config HeaderConfig) elton.Handler {
 		c.SetHeader(config.Name, id)
 		return nil
 	}
+	clearID := func(c *elton.Context) error {
+		// set empty session id to response header,
+		// c.SetHeader will remove the header if value is empty
+		c.Header().Set(config.Name, "")
+		return nil
+	}
 	return New(Config{
-		Store:   config.Store,
-		Get:     getID,
-		Set:     setID,
-		GenID:   config.GenID,
-		Expired: config.Expired,
+		Store:           config.Store,
+		Get:             getID,
+		Set:             setID,
+		Clear:           clearID,
+		GenID:           config.GenID,
+		Expired:         config.Expired,
+		Rolling:         config.Rolling,
+		RollingInterval: config.RollingInterval,
+		IdleTimeout:     config.IdleTimeout,
+		MaxLifetime:     config.MaxLifetime,
+		Codec:           config.Codec,
+		ConflictRetry:   config.ConflictRetry,
 	})
 }
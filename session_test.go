@@ -26,13 +26,17 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -240,6 +244,778 @@ func TestSession(t *testing.T) {
 
 }
 
+func TestRegenerate(t *testing.T) {
+	assert := assert.New(t)
+	store, err := NewMemoryStore(10)
+	ctx := context.Background()
+	assert.Nil(err, "new memory store fail")
+	oldID := generateID()
+	newID := generateID()
+
+	s := Session{
+		Store: store,
+		ID:    oldID,
+	}
+	err = s.Regenerate(ctx)
+	assert.Equal(ErrGenIDNil, err)
+
+	s.GenID = func() string {
+		return newID
+	}
+	_ = s.Set(ctx, "a", 1)
+	err = s.Commit(ctx, time.Minute)
+	assert.Nil(err)
+
+	err = s.Regenerate(ctx)
+	assert.Nil(err)
+	assert.Equal(newID, s.ID)
+	assert.True(s.idChanged)
+	assert.Equal(1, s.GetInt("a"), "data should be kept after regenerate")
+	buf, err := store.Get(ctx, oldID)
+	assert.Nil(err)
+	assert.Empty(buf, "old session should be removed")
+
+	err = s.Commit(ctx, time.Minute)
+	assert.Nil(err, "commit after regenerate fail")
+	buf, err = store.Get(ctx, newID)
+	assert.Nil(err)
+	assert.NotEmpty(buf)
+
+	s.EnableReadonly()
+	err = s.Regenerate(ctx)
+	assert.Equal(ErrIsReadonly, err)
+}
+
+func TestRoll(t *testing.T) {
+	assert := assert.New(t)
+	store, err := NewMemoryStore(10)
+	ctx := context.Background()
+	assert.Nil(err, "new memory store fail")
+	ttl := time.Minute
+
+	s := Session{
+		Store: store,
+	}
+	rolled, err := s.roll(ctx, ttl, 0)
+	assert.Nil(err)
+	assert.False(rolled, "session without id shouldn't be rolled")
+
+	s.ID = generateID()
+	rolled, err = s.roll(ctx, ttl, 0)
+	assert.Nil(err)
+	assert.False(rolled, "session not in store shouldn't be rolled")
+
+	// 隐藏Touch，使用Set写入
+	setStore := struct{ Store }{store}
+	_ = store.Set(ctx, s.ID, []byte(`{"a":1,"_expiredAt":"2020-01-01T00:00:00Z"}`), time.Second)
+	s = Session{
+		Store: setStore,
+		ID:    s.ID,
+	}
+	rolled, err = s.roll(ctx, ttl, time.Minute)
+	assert.Nil(err)
+	assert.True(rolled)
+	assert.False(s.modified, "roll shouldn't modify session")
+	assert.NotEmpty(s.GetUpdatedAt(), "roll should refresh updated at")
+	info, _ := store.client.Get(s.ID)
+	assert.GreaterOrEqual(info.ExpiredAt, time.Now().Add(ttl).Unix()-1, "ttl should be extended")
+
+	s = Session{
+		Store: setStore,
+		ID:    s.ID,
+	}
+	rolled, err = s.roll(ctx, ttl, time.Minute)
+	assert.Nil(err)
+	assert.False(rolled, "roll should be throttled by interval")
+
+	// 使用Touch延长有效期
+	id := generateID()
+	_ = store.Set(ctx, id, []byte(`{"a":1}`), time.Second)
+	s = Session{
+		Store: store,
+		ID:    id,
+	}
+	rolled, err = s.roll(ctx, ttl, 0)
+	assert.Nil(err)
+	assert.True(rolled)
+	assert.Empty(s.GetUpdatedAt(), "touch shouldn't write data")
+	info, _ = store.client.Get(id)
+	assert.GreaterOrEqual(info.ExpiredAt, time.Now().Add(ttl).Unix()-1, "ttl should be extended")
+	assert.Equal([]byte(`{"a":1}`), info.Data)
+
+	// 根据store的剩余ttl判断rolling的间隔
+	id = generateID()
+	_ = store.Set(ctx, id, []byte(`{"a":1}`), 20*time.Minute)
+	count := 0
+	for i := 0; i < 5; i++ {
+		s = Session{
+			Store: store,
+			ID:    id,
+		}
+		rolled, err = s.roll(ctx, 30*time.Minute, 5*time.Minute)
+		assert.Nil(err)
+		if rolled {
+			count++
+		}
+	}
+	assert.Equal(1, count, "touch should be throttled by interval")
+
+	// idle timeout需要写入updated at
+	s = Session{
+		Store:       store,
+		ID:          id,
+		IdleTimeout: time.Hour,
+	}
+	rolled, err = s.roll(ctx, ttl, 0)
+	assert.Nil(err)
+	assert.True(rolled)
+	assert.NotEmpty(s.GetUpdatedAt(), "roll should refresh updated at")
+}
+
+func TestSessionTimeout(t *testing.T) {
+	assert := assert.New(t)
+	store, err := NewMemoryStore(10)
+	ctx := context.Background()
+	assert.Nil(err, "new memory store fail")
+	now := time.Now()
+	format := func(d time.Duration) string {
+		return now.Add(-d).Format(time.RFC3339)
+	}
+	tests := []struct {
+		data    M
+		timeout bool
+	}{
+		// active session
+		{
+			data: M{
+				CreatedAt: format(time.Hour),
+				UpdatedAt: format(time.Minute),
+			},
+			timeout: false,
+		},
+		// idle timeout
+		{
+			data: M{
+				CreatedAt: format(time.Hour),
+				UpdatedAt: format(40 * time.Minute),
+			},
+			timeout: true,
+		},
+		// idle timeout by created at
+		{
+			data: M{
+				CreatedAt: format(40 * time.Minute),
+			},
+			timeout: true,
+		},
+		// max lifetime
+		{
+			data: M{
+				CreatedAt: format(13 * time.Hour),
+				UpdatedAt: format(time.Minute),
+			},
+			timeout: true,
+		},
+		// no metadata
+		{
+			data:    M{},
+			timeout: false,
+		},
+	}
+	for _, tt := range tests {
+		id := generateID()
+		tt.data["a"] = 1
+		buf, _ := json.Marshal(tt.data)
+		_ = store.Set(ctx, id, buf, time.Minute)
+		s := Session{
+			Store:       store,
+			ID:          id,
+			IdleTimeout: 30 * time.Minute,
+			MaxLifetime: 12 * time.Hour,
+		}
+		_, err = s.Fetch(ctx)
+		assert.Nil(err)
+		if tt.timeout {
+			assert.Empty(s.ID, "timeout session should reset id")
+			assert.True(s.destroyed)
+			assert.Empty(s.Get("a"), "timeout session should be empty")
+			assert.NotEmpty(s.GetCreatedAt())
+			buf, _ = store.Get(ctx, id)
+			assert.Empty(buf, "timeout session should be removed from store")
+		} else {
+			assert.Equal(id, s.ID)
+			assert.Equal(1, s.GetInt("a"))
+		}
+	}
+}
+
+func TestIdleTimeoutRequireRolling(t *testing.T) {
+	assert := assert.New(t)
+	store, _ := NewMemoryStore(10)
+	config := CookieConfig{
+		Store:       store,
+		Expired:     time.Hour,
+		GenID:       generateID,
+		Name:        "jt",
+		IdleTimeout: 30 * time.Minute,
+	}
+	assert.PanicsWithValue("require rolling for idle timeout", func() {
+		NewByCookie(config)
+	})
+	config.Rolling = true
+	config.RollingInterval = time.Hour
+	assert.PanicsWithValue("rolling interval should be less than idle timeout", func() {
+		NewByCookie(config)
+	})
+	config.RollingInterval = time.Minute
+	assert.NotPanics(func() {
+		NewByCookie(config)
+	})
+}
+
+type ctxKey struct{}
+
+// testStore the store for test, it records the context of get
+// and returns error if err is set
+type testStore struct {
+	Store
+	err error
+	ctx context.Context
+}
+
+func (ts *testStore) Get(ctx context.Context, key string) ([]byte, error) {
+	ts.ctx = ctx
+	if ts.err != nil {
+		return nil, ts.err
+	}
+	return ts.Store.Get(ctx, key)
+}
+
+func TestLookup(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	memoryStore, err := NewMemoryStore(10)
+	assert.Nil(err, "new memory store fail")
+	store := &testStore{
+		Store: memoryStore,
+		err:   errors.New("connection refused"),
+	}
+	id := generateID()
+	_ = memoryStore.Set(ctx, id, []byte(`{"a":1}`), time.Minute)
+	s := Session{
+		Store: store,
+		ID:    id,
+	}
+	_, _, err = s.Lookup(ctx, "a")
+	assert.Equal(store.err, err, "lookup should return the error of store")
+	assert.Nil(s.Get("a"), "get should ignore the error of store")
+
+	store.err = nil
+	value, ok, err := s.Lookup(ctx, "a")
+	assert.Nil(err)
+	assert.True(ok)
+	assert.Equal(json.Number("1"), value)
+
+	_, ok, err = s.Lookup(ctx, "b")
+	assert.Nil(err)
+	assert.False(ok)
+
+	// middleware should use the request context for getters
+	fn := New(Config{
+		Store:     store,
+		LazyFetch: true,
+		Get: func(c *elton.Context) (string, error) {
+			return id, nil
+		},
+		Set: func(c *elton.Context, id string) error {
+			return nil
+		},
+		GenID:   generateID,
+		Expired: time.Minute,
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+	reqCtx := context.WithValue(req.Context(), ctxKey{}, "request")
+	c := elton.NewContext(httptest.NewRecorder(), req.WithContext(reqCtx))
+	c.Next = func() error {
+		assert.Equal(1, MustGet(c).GetInt("a"))
+		return nil
+	}
+	err = fn(c)
+	assert.Nil(err)
+	assert.Equal("request", store.ctx.Value(ctxKey{}), "getter should use the request context")
+}
+
+func TestSessionConcurrency(t *testing.T) {
+	assert := assert.New(t)
+	store, err := NewMemoryStore(10)
+	ctx := context.Background()
+	assert.Nil(err, "new memory store fail")
+	id := generateID()
+	_ = store.Set(ctx, id, []byte(`{"a":1}`), time.Minute)
+	s := &Session{
+		Store: store,
+		ID:    id,
+		GenID: generateID,
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			key := strconv.Itoa(index)
+			_, _ = s.Fetch(ctx)
+			_ = s.Set(ctx, key, index)
+			_ = s.SetMap(ctx, map[string]interface{}{
+				key + "-map": index,
+			})
+			_ = s.Refresh(ctx)
+			_ = s.Flash(ctx, "info", key)
+			_ = s.GetInt(key)
+			_, _, _ = s.Lookup(ctx, "a")
+			_ = s.GetData()
+			_ = s.Readonly()
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < 10; i++ {
+		assert.Equal(i, s.GetInt(strconv.Itoa(i)))
+	}
+	messages, err := s.Flashes(ctx, "info")
+	assert.Nil(err)
+	assert.Equal(10, len(messages))
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- s.Commit(ctx, time.Minute)
+		}()
+	}
+	results := []error{
+		<-errs,
+		<-errs,
+	}
+	assert.Contains(results, nil)
+	assert.Contains(results, error(ErrDuplicateCommit), "commit should be only once")
+}
+
+func TestSessionConflict(t *testing.T) {
+	ctx := context.Background()
+	newSessions := func(retry int) (Store, *Session, *Session) {
+		store, _ := NewMemoryStore(10)
+		id := generateID()
+		_ = store.Set(ctx, id, []byte(`{"a":1,"b":2}`), time.Minute)
+		newSession := func() *Session {
+			s := &Session{
+				Store:         store,
+				ID:            id,
+				ConflictRetry: retry,
+			}
+			_, _ = s.Fetch(ctx)
+			return s
+		}
+		return store, newSession(), newSession()
+	}
+
+	t.Run("conflict", func(t *testing.T) {
+		assert := assert.New(t)
+		_, s1, s2 := newSessions(-1)
+		assert.Nil(s1.Set(ctx, "c", 3))
+		assert.Nil(s2.Set(ctx, "d", 4))
+		assert.Nil(s1.Commit(ctx, time.Minute))
+		err := s2.Commit(ctx, time.Minute)
+		assert.Equal(ErrConflict, err)
+	})
+
+	t.Run("merge by default", func(t *testing.T) {
+		assert := assert.New(t)
+		store, s1, s2 := newSessions(0)
+		assert.Nil(s1.Set(ctx, "c", 3))
+		assert.Nil(s2.Set(ctx, "d", 4))
+		assert.Nil(s1.Commit(ctx, time.Minute))
+		assert.Nil(s2.Commit(ctx, time.Minute))
+
+		s := &Session{
+			Store: store,
+			ID:    s1.ID,
+		}
+		_, err := s.Fetch(ctx)
+		assert.Nil(err)
+		assert.Equal(3, s.GetInt("c"))
+		assert.Equal(4, s.GetInt("d"))
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		assert := assert.New(t)
+		store, s1, s2 := newSessions(1)
+		assert.Nil(s1.Set(ctx, "c", 3))
+		assert.Nil(s1.SetMap(ctx, map[string]interface{}{
+			"a": nil,
+		}))
+		assert.Nil(s2.Set(ctx, "d", 4))
+		assert.Nil(s1.Commit(ctx, time.Minute))
+		assert.Nil(s2.Commit(ctx, time.Minute))
+
+		s := &Session{
+			Store: store,
+			ID:    s1.ID,
+		}
+		data, err := s.Fetch(ctx)
+		assert.Nil(err)
+		assert.Nil(data["a"], "the deleted key should not be restored")
+		assert.Equal(2, s.GetInt("b"))
+		assert.Equal(3, s.GetInt("c"))
+		assert.Equal(4, s.GetInt("d"))
+		assert.Equal(int64(2), s.Metadata().Version)
+	})
+
+	t.Run("destroyed by other request", func(t *testing.T) {
+		assert := assert.New(t)
+		store, s1, s2 := newSessions(3)
+		assert.Nil(s1.Destroy(ctx))
+		assert.Nil(s2.Set(ctx, "cart", 1))
+		err := s2.Commit(ctx, time.Minute)
+		assert.Equal(ErrConflict, err)
+		buf, _ := store.Get(ctx, s2.ID)
+		assert.Empty(buf, "destroyed session should not be written back")
+	})
+
+	t.Run("merge the same key", func(t *testing.T) {
+		assert := assert.New(t)
+		store, s1, s2 := newSessions(1)
+		assert.Nil(s1.Set(ctx, "a", 10))
+		assert.Nil(s2.Set(ctx, "a", 20))
+		assert.Nil(s1.Commit(ctx, time.Minute))
+		assert.Nil(s2.Commit(ctx, time.Minute))
+
+		s := &Session{
+			Store: store,
+			ID:    s1.ID,
+		}
+		_, err := s.Fetch(ctx)
+		assert.Nil(err)
+		assert.Equal(20, s.GetInt("a"), "the last commit should win")
+	})
+}
+
+func TestDeleteAndClear(t *testing.T) {
+	assert := assert.New(t)
+	store, _ := NewMemoryStore(10)
+	ctx := context.Background()
+	id := generateID()
+	createdAt := "2020-01-01T00:00:00Z"
+	_ = store.Set(ctx, id, []byte(`{"_createdAt":"`+createdAt+`","a":1,"b":2,"c":3}`), time.Minute)
+	s := &Session{
+		Store: store,
+		ID:    id,
+	}
+	assert.True(s.Has("a"))
+	assert.False(s.Has("d"))
+	assert.False(s.Has(CreatedAt), "the reserved key should be excluded like keys")
+	assert.Equal([]string{
+		"a",
+		"b",
+		"c",
+	}, s.Keys())
+
+	assert.Equal(ErrReservedKey, s.Delete(ctx, "a", CreatedAt))
+	assert.True(s.Has("a"), "no key should be deleted if there is reserved key")
+
+	// delete not exists key
+	assert.Nil(s.Delete(ctx, "d"))
+	assert.False(s.modified, "delete not exists key should not modify session")
+
+	assert.Nil(s.Delete(ctx, "a"))
+	assert.True(s.modified)
+	assert.False(s.Has("a"))
+	assert.Equal([]string{
+		"b",
+		"c",
+	}, s.Keys())
+
+	assert.Nil(s.Clear(ctx))
+	assert.Empty(s.Keys())
+	assert.Equal(createdAt, s.GetCreatedAt(), "created at should be kept")
+	assert.Equal([]string{
+		"a",
+		"b",
+		"c",
+	}, s.ChangedKeys())
+
+	assert.Nil(s.Commit(ctx, time.Minute))
+	s = &Session{
+		Store: store,
+		ID:    id,
+	}
+	assert.Empty(s.Keys())
+	assert.Equal(createdAt, s.GetCreatedAt())
+
+	// clear empty session
+	assert.Nil(s.Clear(ctx))
+	assert.False(s.modified, "clear empty session should not modify session")
+
+	s.EnableReadonly()
+	assert.Equal(ErrIsReadonly, s.Delete(ctx, "a"))
+	assert.Equal(ErrIsReadonly, s.Clear(ctx))
+}
+
+func TestReservedKey(t *testing.T) {
+	assert := assert.New(t)
+	store, _ := NewMemoryStore(10)
+	ctx := context.Background()
+	s := &Session{
+		Store: store,
+	}
+	assert.Equal(ErrReservedKey, s.Set(ctx, CreatedAt, "2020-01-01T00:00:00Z"))
+	assert.Equal(ErrReservedKey, s.SetMap(ctx, map[string]interface{}{
+		"a":      1,
+		FlashKey: "tree",
+	}))
+	assert.False(s.Has("a"), "no data should be set if there is reserved key")
+	assert.Equal(ErrReservedKey, s.Delete(ctx, FlashKey))
+	assert.Equal(ErrReservedKey, SetAs(ctx, s, ExpiredAt, 1))
+
+	// the other keys start with "_" are not reserved
+	assert.Nil(s.Set(ctx, "_csrf", "token"))
+	assert.True(s.Has("_csrf"))
+	assert.Equal([]string{"_csrf"}, s.Keys())
+	assert.Nil(s.Delete(ctx, "_csrf"))
+	assert.False(s.Has("_csrf"))
+}
+
+func TestMetadata(t *testing.T) {
+	assert := assert.New(t)
+	store, _ := NewMemoryStore(10)
+	ctx := context.Background()
+	id := generateID()
+	createdAt := "2020-01-01T00:00:00Z"
+	_ = store.Set(ctx, id, []byte(`{"_createdAt":"`+createdAt+`","_version":3,"a":1}`), time.Minute)
+	s := &Session{
+		Store: store,
+		ID:    id,
+	}
+	data, err := s.Fetch(ctx)
+	assert.Nil(err)
+	assert.Equal(1, len(data), "metadata should not be mixed into data")
+	meta := s.Metadata()
+	assert.Equal(createdAt, meta.CreatedAt.UTC().Format(time.RFC3339))
+	assert.True(meta.UpdatedAt.IsZero())
+	assert.Equal(int64(3), meta.Version)
+	assert.Nil(s.Get(CreatedAt))
+
+	assert.Nil(s.Set(ctx, "b", 2))
+	assert.False(s.Metadata().UpdatedAt.IsZero())
+	assert.Nil(s.Commit(ctx, time.Minute))
+	meta = s.Metadata()
+	assert.Equal(int64(4), meta.Version)
+	assert.True(meta.ExpiredAt.After(time.Now()))
+
+	// the metadata is stored under the same keys
+	buf, _ := store.Get(ctx, id)
+	m := make(M)
+	assert.Nil(json.Unmarshal(buf, &m))
+	assert.Equal(createdAt, m[CreatedAt])
+	assert.Equal(float64(4), m[Version])
+	assert.Equal(meta.UpdatedAt.Format(time.RFC3339Nano), m[UpdatedAt])
+	assert.Equal(meta.ExpiredAt.Format(time.RFC3339Nano), m[ExpiredAt])
+}
+
+func TestTimeAccessors(t *testing.T) {
+	assert := assert.New(t)
+	store, _ := NewMemoryStore(10)
+	ctx := context.Background()
+	id := generateID()
+	now := time.Now()
+	createdAt := now.Add(-time.Hour)
+	updatedAt := now.Add(-10*time.Minute - 500*time.Millisecond)
+	buf, _ := json.Marshal(M{
+		CreatedAt: createdAt.Format(time.RFC3339Nano),
+		UpdatedAt: updatedAt.Format(time.RFC3339Nano),
+		"a":       1,
+	})
+	_ = store.Set(ctx, id, buf, time.Minute)
+	s := &Session{
+		Store: store,
+		ID:    id,
+	}
+	assert.True(createdAt.Equal(s.CreatedAt()), "sub-second precision should be kept")
+	assert.True(updatedAt.Equal(s.UpdatedAt()), "sub-second precision should be kept")
+	assert.True(s.ExpiredAt().IsZero())
+	assert.Equal(createdAt.Format(time.RFC3339), s.GetCreatedAt())
+	assert.Equal(updatedAt.Format(time.RFC3339), s.GetUpdatedAt())
+	assert.Empty(s.GetExpiredAt())
+
+	age := s.Age()
+	assert.GreaterOrEqual(age, time.Hour)
+	assert.Less(age, time.Hour+time.Minute)
+	idle := s.IdleFor()
+	assert.GreaterOrEqual(idle, 10*time.Minute+500*time.Millisecond)
+	assert.Less(idle, 11*time.Minute)
+
+	// the time without sub-second(stored by old version)
+	id = generateID()
+	_ = store.Set(ctx, id, []byte(`{"_createdAt":"2020-01-01T00:00:00Z"}`), time.Minute)
+	s = &Session{
+		Store: store,
+		ID:    id,
+	}
+	assert.Equal("2020-01-01T00:00:00Z", s.CreatedAt().UTC().Format(time.RFC3339Nano))
+	assert.Greater(s.IdleFor(), time.Hour, "idle time should use created at if not updated")
+
+	// unknown created time
+	id = generateID()
+	_ = store.Set(ctx, id, []byte(`{"a":1}`), time.Minute)
+	s = &Session{
+		Store: store,
+		ID:    id,
+	}
+	assert.Equal(time.Duration(0), s.Age())
+	assert.Equal(time.Duration(0), s.IdleFor())
+}
+
+func TestChangedKeys(t *testing.T) {
+	assert := assert.New(t)
+	store, _ := NewMemoryStore(10)
+	ctx := context.Background()
+	s := &Session{
+		Store: store,
+	}
+	assert.Empty(s.ChangedKeys())
+	assert.Nil(s.Set(ctx, "b", 1))
+	assert.Nil(s.SetMap(ctx, map[string]interface{}{
+		"c": nil,
+		"a": "a",
+	}))
+	assert.Nil(s.Set(ctx, "b", 2))
+	assert.Equal([]string{
+		"a",
+		"b",
+		"c",
+	}, s.ChangedKeys())
+}
+
+// testFieldStore the field store for test, it records the last updated fields
+type testFieldStore struct {
+	mu      sync.Mutex
+	data    map[string]map[string][]byte
+	fields  []string
+	removed []string
+}
+
+func (fs *testFieldStore) Get(_ context.Context, _ string) ([]byte, error) {
+	return nil, errors.New("get fields instead")
+}
+
+func (fs *testFieldStore) Set(_ context.Context, _ string, _ []byte, _ time.Duration) error {
+	return errors.New("set fields instead")
+}
+
+func (fs *testFieldStore) Destroy(_ context.Context, key string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	delete(fs.data, key)
+	return nil
+}
+
+func (fs *testFieldStore) GetFields(_ context.Context, key string) (map[string][]byte, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.data[key], nil
+}
+
+func (fs *testFieldStore) SetFields(_ context.Context, key string, fields map[string][]byte, removed []string, _ time.Duration) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.data == nil {
+		fs.data = make(map[string]map[string][]byte)
+	}
+	m := fs.data[key]
+	if m == nil {
+		if isPartialFields(fields) {
+			return ErrConflict
+		}
+		m = make(map[string][]byte)
+		fs.data[key] = m
+	}
+	fs.fields = fs.fields[:0]
+	for k, v := range fields {
+		m[k] = v
+		fs.fields = append(fs.fields, k)
+	}
+	sort.Strings(fs.fields)
+	for _, k := range removed {
+		delete(m, k)
+	}
+	fs.removed = removed
+	return nil
+}
+
+func TestFieldStore(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	for _, codec := range []Codec{
+		JSONCodec,
+		GobCodec,
+		MsgpackCodec,
+	} {
+		store := &testFieldStore{}
+		id := generateID()
+		s := &Session{
+			Store: store,
+			ID:    id,
+			Codec: codec,
+		}
+		assert.Nil(s.SetMap(ctx, map[string]interface{}{
+			"a": "a",
+			"b": 1,
+			"c": true,
+		}))
+		assert.Nil(s.Commit(ctx, time.Minute))
+		// new session writes all fields
+		assert.Equal([]string{
+			CreatedAt,
+			ExpiredAt,
+			UpdatedAt,
+			Version,
+			"a",
+			"b",
+			"c",
+		}, store.fields)
+
+		s = &Session{
+			Store: store,
+			ID:    id,
+			Codec: codec,
+		}
+		assert.Nil(s.SetMap(ctx, map[string]interface{}{
+			"a": nil,
+			"b": 2,
+		}))
+		assert.Nil(s.Commit(ctx, time.Minute))
+		// only the changed fields and metadata are written
+		assert.Equal([]string{
+			ExpiredAt,
+			UpdatedAt,
+			Version,
+			"b",
+		}, store.fields)
+		assert.Equal([]string{
+			"a",
+		}, store.removed)
+
+		s = &Session{
+			Store: store,
+			ID:    id,
+			Codec: codec,
+		}
+		data, err := s.Fetch(ctx)
+		assert.Nil(err)
+		assert.Nil(data["a"])
+		assert.Equal(2, s.GetInt("b"))
+		assert.True(s.GetBool("c"))
+		assert.Equal(int64(2), s.Metadata().Version)
+	}
+}
+
 func TestNotFetchError(t *testing.T) {
 	assert := assert.New(t)
 	ctx := context.Background()
@@ -335,6 +1111,52 @@ func TestSessionMiddleware(t *testing.T) {
 		assert.Nil(err, "session by cookie middleware fail")
 	})
 
+	t.Run("regenerate session id", func(t *testing.T) {
+		assert := assert.New(t)
+		oldID := generateID()
+		_ = store.Set(ctx, oldID, []byte(`{"foo":"bar"}`), time.Minute)
+		req := httptest.NewRequest("GET", "/login", nil)
+		req.AddCookie(&http.Cookie{
+			Name:  idName,
+			Value: oldID,
+		})
+		resp := httptest.NewRecorder()
+		c := elton.NewContext(resp, req)
+		c.Next = func() error {
+			return MustGet(c).Regenerate(ctx)
+		}
+		err = cookieSessionMiddleware(c)
+		assert.Nil(err, "regenerate session id fail")
+		assert.Equal(c.Header()["Set-Cookie"], []string{
+			"jt=abcd; Path=/; Domain=abc.com; Max-Age=60; HttpOnly; Secure",
+		}, "set cookie fail")
+		buf, _ := store.Get(ctx, oldID)
+		assert.Empty(buf, "old session should be removed")
+	})
+
+	t.Run("destroy session by cookie", func(t *testing.T) {
+		assert := assert.New(t)
+		id := generateID()
+		_ = store.Set(ctx, id, []byte(`{"foo":"bar"}`), time.Minute)
+		req := httptest.NewRequest("GET", "/logout", nil)
+		req.AddCookie(&http.Cookie{
+			Name:  idName,
+			Value: id,
+		})
+		resp := httptest.NewRecorder()
+		c := elton.NewContext(resp, req)
+		c.Next = func() error {
+			return MustGet(c).Destroy(ctx)
+		}
+		err = cookieSessionMiddleware(c)
+		assert.Nil(err, "destroy session fail")
+		assert.Equal(c.Header()["Set-Cookie"], []string{
+			"jt=; Path=/; Domain=abc.com; Max-Age=0; HttpOnly; Secure",
+		}, "clear cookie fail")
+		buf, _ := store.Get(ctx, id)
+		assert.Empty(buf, "session should be removed")
+	})
+
 	headerSessionMiddleware := NewByHeader(HeaderConfig{
 		Store:   store,
 		Expired: 10 * time.Millisecond,
@@ -375,6 +1197,64 @@ func TestSessionMiddleware(t *testing.T) {
 		err = headerSessionMiddleware(c)
 		assert.Nil(err, "session by header middleware fail")
 	})
+
+	t.Run("rolling session by cookie", func(t *testing.T) {
+		assert := assert.New(t)
+		rollingSessionMiddleware := NewByCookie(CookieConfig{
+			Store:   struct{ Store }{store},
+			Expired: time.Minute,
+			GenID: func() string {
+				return uid
+			},
+			Rolling:         true,
+			RollingInterval: time.Hour,
+			Name:            idName,
+			MaxAge:          60,
+		})
+		id := generateID()
+		_ = store.Set(ctx, id, []byte(`{"foo":"bar"}`), time.Second)
+		newRequest := func() *elton.Context {
+			req := httptest.NewRequest("GET", "/users/me", nil)
+			req.AddCookie(&http.Cookie{
+				Name:  idName,
+				Value: id,
+			})
+			c := elton.NewContext(httptest.NewRecorder(), req)
+			c.Next = func() error {
+				return nil
+			}
+			return c
+		}
+		c := newRequest()
+		err := rollingSessionMiddleware(c)
+		assert.Nil(err, "rolling session fail")
+		assert.Equal([]string{
+			"jt=" + id + "; Max-Age=60",
+		}, c.Header()["Set-Cookie"], "cookie should be reissued")
+		info, _ := store.client.Get(id)
+		assert.GreaterOrEqual(info.ExpiredAt, time.Now().Add(time.Minute).Unix()-1, "ttl should be extended")
+
+		c = newRequest()
+		err = rollingSessionMiddleware(c)
+		assert.Nil(err, "rolling session fail")
+		assert.Empty(c.Header()["Set-Cookie"], "rolling should be throttled")
+	})
+
+	t.Run("destroy session by header", func(t *testing.T) {
+		assert := assert.New(t)
+		id := generateID()
+		_ = store.Set(ctx, id, []byte(`{"foo":"bar"}`), time.Minute)
+		req := httptest.NewRequest("GET", "/logout", nil)
+		req.Header.Set(idName, id)
+		resp := httptest.NewRecorder()
+		c := elton.NewContext(resp, req)
+		c.Next = func() error {
+			return MustGet(c).Destroy(ctx)
+		}
+		err = headerSessionMiddleware(c)
+		assert.Nil(err, "destroy session fail")
+		assert.Equal([]string{""}, c.Header().Values(idName), "clear header fail")
+	})
 }
 
 // https://stackoverflow.com/questions/50120427/fail-unit-tests-if-coverage-is-below-certain-percentage
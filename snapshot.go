@@ -0,0 +1,152 @@
+// MIT License
+
+// Copyright (c) 2020 Tree Xie
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package session
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	snapshotMagic   = "elton-session-snapshot"
+	snapshotVersion = 1
+	// snapshotPreviousSuffix the suffix of previous snapshot file
+	snapshotPreviousSuffix = ".prev"
+)
+
+var (
+	// ErrSnapshotInvalid snapshot is invalid
+	ErrSnapshotInvalid = createError("snapshot is invalid")
+	// ErrSnapshotVersion snapshot version is not supported
+	ErrSnapshotVersion = createError("snapshot version is not supported")
+)
+
+// encodeSnapshot adds the header(magic, version and checksum) to data,
+// the header is "elton-session-snapshot 1 sha256\n"
+func encodeSnapshot(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	header := fmt.Sprintf("%s %d %s\n", snapshotMagic, snapshotVersion, hex.EncodeToString(sum[:]))
+	buf := make([]byte, 0, len(header)+len(data))
+	buf = append(buf, header...)
+	return append(buf, data...)
+}
+
+// decodeSnapshot validates the header of snapshot and returns the data
+func decodeSnapshot(buf []byte) ([]byte, error) {
+	// 旧版本的snapshot无header，直接为json
+	if len(buf) != 0 && buf[0] == '{' {
+		if !json.Valid(buf) {
+			return nil, ErrSnapshotInvalid
+		}
+		return buf, nil
+	}
+	index := bytes.IndexByte(buf, '\n')
+	if index < 0 {
+		return nil, ErrSnapshotInvalid
+	}
+	var magic, checksum string
+	var version int
+	_, err := fmt.Sscanf(string(buf[:index]), "%s %d %s", &magic, &version, &checksum)
+	if err != nil || magic != snapshotMagic {
+		return nil, ErrSnapshotInvalid
+	}
+	if version != snapshotVersion {
+		return nil, ErrSnapshotVersion
+	}
+	data := buf[index+1:]
+	sum := sha256.Sum256(data)
+	if checksum != hex.EncodeToString(sum[:]) {
+		return nil, ErrSnapshotInvalid
+	}
+	return data, nil
+}
+
+// readSnapshot reads the snapshot file and validates it
+func readSnapshot(file string) (map[string]*MemoryStoreInfo, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	data, err := decodeSnapshot(buf)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]*MemoryStoreInfo)
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// writeSnapshot writes the snapshot to a temp file, syncs and renames it to file,
+// so the file is always a complete snapshot even if crash when writing.
+// The current file will be kept as previous generation if keepPrevious is true.
+func writeSnapshot(file string, data []byte, keepPrevious bool) (err error) {
+	dir := filepath.Dir(file)
+	f, err := os.CreateTemp(dir, filepath.Base(file)+".tmp*")
+	if err != nil {
+		return
+	}
+	tmp := f.Name()
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp)
+		}
+	}()
+	_, err = f.Write(encodeSnapshot(data))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+	err = os.Chmod(tmp, 0600)
+	if err != nil {
+		return
+	}
+	if keepPrevious {
+		err = os.Rename(file, file+snapshotPreviousSuffix)
+		if err != nil && !os.IsNotExist(err) {
+			return
+		}
+	}
+	err = os.Rename(tmp, file)
+	if err != nil {
+		return
+	}
+	// 同步目录，确保rename已落盘（部分系统不支持，忽略出错）
+	if d, e := os.Open(dir); e == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// MIT License
+
+// Copyright (c) 2020 Tree Xie
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package session
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotEncodeDecode(t *testing.T) {
+	assert := assert.New(t)
+	data := []byte(`{"a":{"ExpiredAt":1,"Data":"YWJj"}}`)
+	buf := encodeSnapshot(data)
+	assert.True(strings.HasPrefix(string(buf), snapshotMagic+" 1 "))
+
+	result, err := decodeSnapshot(buf)
+	assert.Nil(err)
+	assert.Equal(data, result)
+
+	// legacy snapshot without header
+	result, err = decodeSnapshot(data)
+	assert.Nil(err)
+	assert.Equal(data, result)
+
+	// truncated legacy snapshot
+	_, err = decodeSnapshot(data[:10])
+	assert.Equal(ErrSnapshotInvalid, err)
+
+	// truncated snapshot
+	_, err = decodeSnapshot(buf[:len(buf)-2])
+	assert.Equal(ErrSnapshotInvalid, err)
+
+	_, err = decodeSnapshot([]byte("abcd"))
+	assert.Equal(ErrSnapshotInvalid, err)
+	_, err = decodeSnapshot(nil)
+	assert.Equal(ErrSnapshotInvalid, err)
+
+	_, err = decodeSnapshot([]byte(strings.Replace(string(buf), " 1 ", " 2 ", 1)))
+	assert.Equal(ErrSnapshotVersion, err)
+}
+
+func TestWriteSnapshot(t *testing.T) {
+	assert := assert.New(t)
+	file := filepath.Join(t.TempDir(), "store")
+	prev := file + snapshotPreviousSuffix
+
+	err := writeSnapshot(file, []byte(`{}`), true)
+	assert.Nil(err)
+	_, err = os.Stat(prev)
+	assert.True(os.IsNotExist(err), "previous snapshot shouldn't exist")
+
+	err = writeSnapshot(file, []byte(`{"a":{"ExpiredAt":1,"Data":null}}`), true)
+	assert.Nil(err)
+	m, err := readSnapshot(file)
+	assert.Nil(err)
+	assert.Equal(1, len(m))
+	m, err = readSnapshot(prev)
+	assert.Nil(err)
+	assert.Equal(0, len(m))
+
+	info, err := os.Stat(file)
+	assert.Nil(err)
+	assert.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	entries, err := os.ReadDir(filepath.Dir(file))
+	assert.Nil(err)
+	assert.Equal(2, len(entries), "temp file should be removed")
+
+	err = writeSnapshot(filepath.Join(file, "store"), []byte(`{}`), false)
+	assert.NotNil(err)
+}
+
+func TestMemoryStoreRestorePrevious(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "store")
+	store, err := NewMemoryStore(10)
+	assert.Nil(err)
+	store.saveAs = file
+	store.keepPrevious = true
+	_ = store.Set(ctx, "a", []byte("a"), time.Minute)
+	assert.Nil(store.flush())
+	_ = store.Set(ctx, "b", []byte("b"), time.Minute)
+	assert.Nil(store.flush())
+
+	// 模拟写入时crash导致的文件不完整
+	buf, err := os.ReadFile(file)
+	assert.Nil(err)
+	err = os.WriteFile(file, buf[:len(buf)/2], 0600)
+	assert.Nil(err)
+
+	store, err = NewMemoryStoreByConfig(MemoryStoreConfig{
+		Size:         10,
+		SaveAs:       file,
+		KeepPrevious: true,
+	})
+	assert.Nil(err)
+	defer store.StopFlush()
+	data, err := store.Get(ctx, "a")
+	assert.Nil(err)
+	assert.Equal([]byte("a"), data, "should restore from previous snapshot")
+	data, err = store.Get(ctx, "b")
+	assert.Nil(err)
+	assert.Empty(data)
+
+	err = (&MemoryStore{}).flush()
+	assert.Equal(ErrNotInit, err)
+}
@@ -0,0 +1,174 @@
+// MIT License
+
+// Copyright (c) 2020 Tree Xie
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package session
+
+import (
+	"context"
+	"time"
+)
+
+const defaultTieredL1TTL = 5 * time.Second
+
+// TieredStore the two-level store, the memory store(l1) caches the session data
+// of remote store(l2) for a short time to reduce the requests of remote store.
+// The data is written through to both stores and removed from both stores on destroy.
+// In multi-replica deployments the l1 of other replicas may be stale within the l1 ttl,
+// the compare and swap(commit) is always checked by l2, so the stale data is detected.
+// The invalidate hook can be set to notify other replicas(such as redis pub/sub).
+type TieredStore struct {
+	l1           *MemoryStore
+	l2           Store
+	l1TTL        time.Duration
+	onInvalidate TieredInvalidateHook
+}
+
+// TieredInvalidateHook the hook is called after the session data is changed or destroyed
+// by the tiered store, it's used to notify other replicas to invalidate their l1
+type TieredInvalidateHook func(ctx context.Context, key string)
+
+// NewTieredStore create a new tiered store, the l1 ttl is the max ttl of data in l1,
+// default is 5s. The ttl of memory store is second precision, so the l1 ttl should be
+// at least one second.
+func NewTieredStore(l1 *MemoryStore, l2 Store, l1TTL time.Duration) *TieredStore {
+	if l1TTL <= 0 {
+		l1TTL = defaultTieredL1TTL
+	}
+	return &TieredStore{
+		l1:    l1,
+		l2:    l2,
+		l1TTL: l1TTL,
+	}
+}
+
+// cache sets the data to l1, the ttl is bounded by l1 ttl
+func (ts *TieredStore) cache(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	if ttl > ts.l1TTL {
+		ttl = ts.l1TTL
+	}
+	return ts.l1.Set(ctx, key, data, ttl)
+}
+
+// OnInvalidate set the invalidate hook, it should be set before the store is used.
+// The other replicas should call Invalidate when they receive the notification.
+func (ts *TieredStore) OnInvalidate(fn TieredInvalidateHook) {
+	ts.onInvalidate = fn
+}
+
+// Invalidate remove the session data from l1 only, it's used by the notification
+// from other replicas, the data of l2 isn't changed
+func (ts *TieredStore) Invalidate(ctx context.Context, key string) error {
+	return ts.l1.Destroy(ctx, key)
+}
+
+// invalidate notifies other replicas by the invalidate hook
+func (ts *TieredStore) invalidate(ctx context.Context, key string) {
+	if ts.onInvalidate != nil {
+		ts.onInvalidate(ctx, key)
+	}
+}
+
+// Get get the session data from l1, if not exists get it from l2 and cache it to l1
+func (ts *TieredStore) Get(ctx context.Context, key string) ([]byte, error) {
+	buf, err := ts.l1.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) != 0 {
+		return buf, nil
+	}
+	buf, err = ts.l2.Get(ctx, key)
+	if err != nil || len(buf) == 0 {
+		return buf, err
+	}
+	err = ts.cache(ctx, key, buf, ts.l1TTL)
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Set set the session data to l2 and l1(write-through),
+// the data of l1 is removed if it fails to set to l2
+func (ts *TieredStore) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	err := ts.l2.Set(ctx, key, data, ttl)
+	if err != nil {
+		_ = ts.l1.Destroy(ctx, key)
+		return err
+	}
+	ts.invalidate(ctx, key)
+	return ts.cache(ctx, key, data, ttl)
+}
+
+func (ts *TieredStore) requireEltonContext() bool {
+	return requireEltonContext(ts.l2)
+}
+
+// Destroy remove the session data from l1 and l2
+func (ts *TieredStore) Destroy(ctx context.Context, key string) error {
+	err := ts.l1.Destroy(ctx, key)
+	if err != nil {
+		return err
+	}
+	err = ts.l2.Destroy(ctx, key)
+	if err != nil {
+		return err
+	}
+	ts.invalidate(ctx, key)
+	return nil
+}
+
+// Touch extend the ttl of session in l2, the ttl of l1 isn't changed as it's bounded by l1 ttl.
+// If l2 doesn't implement Toucher, the data of l2 is set again with the new ttl
+func (ts *TieredStore) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	return touchStore(ctx, ts.l2, key, ttl)
+}
+
+// TTL get the remaining ttl of session from l2, it returns 0 if l2 doesn't implement Toucher
+func (ts *TieredStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return ttlStore(ctx, ts.l2, key)
+}
+
+// CompareAndSwap set the session data if the current data of l2 is equal to old,
+// the data of l1 is updated if swapped, otherwise it's removed as it may be stale.
+// If l2 doesn't implement Swapper, the data is set without comparing.
+func (ts *TieredStore) CompareAndSwap(ctx context.Context, key string, old, data []byte, ttl time.Duration) (bool, error) {
+	swapper, ok := ts.l2.(Swapper)
+	if !ok {
+		err := ts.Set(ctx, key, data, ttl)
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	swapped, err := swapper.CompareAndSwap(ctx, key, old, data, ttl)
+	if err != nil || !swapped {
+		// 数据可能已被其它实例修改，删除本地缓存
+		_ = ts.l1.Destroy(ctx, key)
+		return false, err
+	}
+	ts.invalidate(ctx, key)
+	err = ts.cache(ctx, key, data, ttl)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
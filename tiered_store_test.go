@@ -0,0 +1,206 @@
+// MIT License
+
+// Copyright (c) 2020 Tree Xie
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTieredStore(t *testing.T) {
+	ctx := context.Background()
+	ttl := time.Minute
+	mr, l2 := newTestRedisStore(t)
+	newReplica := func() (*MemoryStore, *TieredStore) {
+		l1, _ := NewMemoryStore(10)
+		return l1, NewTieredStore(l1, l2, 10*time.Second)
+	}
+	l1, ts := newReplica()
+	otherL1, other := newReplica()
+	data := []byte(`{"a":1}`)
+
+	t.Run("default l1 ttl", func(t *testing.T) {
+		assert := assert.New(t)
+		assert.Equal(defaultTieredL1TTL, NewTieredStore(l1, l2, 0).l1TTL)
+	})
+
+	t.Run("write through", func(t *testing.T) {
+		assert := assert.New(t)
+		key := generateID()
+		err := ts.Set(ctx, key, data, ttl)
+		assert.Nil(err)
+		buf, _ := l2.Get(ctx, key)
+		assert.Equal(data, buf)
+		assert.Equal(ttl, mr.TTL("ss:"+key))
+		info, _ := l1.client.Peek(key)
+		assert.Equal(data, info.Data)
+		assert.LessOrEqual(info.ExpiredAt, time.Now().Add(10*time.Second).Unix(), "l1 ttl should be bounded")
+
+		// other replica gets data from l2 and caches it
+		buf, err = other.Get(ctx, key)
+		assert.Nil(err)
+		assert.Equal(data, buf)
+		buf, _ = otherL1.Get(ctx, key)
+		assert.Equal(data, buf)
+
+		// the data of l1 is used
+		_ = l2.Set(ctx, key, []byte(`{"a":2}`), ttl)
+		buf, _ = other.Get(ctx, key)
+		assert.Equal(data, buf)
+
+		// not exists
+		buf, err = ts.Get(ctx, generateID())
+		assert.Nil(err)
+		assert.Empty(buf)
+	})
+
+	t.Run("destroy", func(t *testing.T) {
+		assert := assert.New(t)
+		key := generateID()
+		_ = ts.Set(ctx, key, data, ttl)
+		err := ts.Destroy(ctx, key)
+		assert.Nil(err)
+		buf, _ := l1.Get(ctx, key)
+		assert.Empty(buf)
+		buf, _ = l2.Get(ctx, key)
+		assert.Empty(buf)
+	})
+
+	t.Run("invalidate hook", func(t *testing.T) {
+		assert := assert.New(t)
+		l1, _ := NewMemoryStore(10)
+		ts := NewTieredStore(l1, l2, 10*time.Second)
+		otherL1, _ := NewMemoryStore(10)
+		other := NewTieredStore(otherL1, l2, 10*time.Second)
+		var keys []string
+		ts.OnInvalidate(func(ctx context.Context, key string) {
+			keys = append(keys, key)
+			_ = other.Invalidate(ctx, key)
+		})
+		key := generateID()
+		_ = ts.Set(ctx, key, data, ttl)
+		buf, _ := other.Get(ctx, key)
+		assert.Equal(data, buf)
+
+		// the data of other replica is invalidated
+		newData := []byte(`{"a":2}`)
+		swapped, err := ts.CompareAndSwap(ctx, key, data, newData, ttl)
+		assert.Nil(err)
+		assert.True(swapped)
+		buf, _ = other.Get(ctx, key)
+		assert.Equal(newData, buf)
+
+		err = ts.Destroy(ctx, key)
+		assert.Nil(err)
+		buf, err = other.Get(ctx, key)
+		assert.Nil(err)
+		assert.Empty(buf, "the destroyed session should not be served by other replica")
+		assert.Equal([]string{key, key, key}, keys)
+
+		// not swapped
+		_, _ = ts.CompareAndSwap(ctx, key, data, newData, ttl)
+		assert.Equal(3, len(keys))
+	})
+
+	t.Run("touch", func(t *testing.T) {
+		assert := assert.New(t)
+		key := generateID()
+		_ = ts.Set(ctx, key, data, ttl)
+		err := ts.Touch(ctx, key, time.Hour)
+		assert.Nil(err)
+		assert.Equal(time.Hour, mr.TTL("ss:"+key))
+		remaining, err := ts.TTL(ctx, key)
+		assert.Nil(err)
+		assert.Equal(time.Hour, remaining)
+	})
+
+	t.Run("compare and swap", func(t *testing.T) {
+		assert := assert.New(t)
+		key := generateID()
+		swapped, err := ts.CompareAndSwap(ctx, key, nil, data, ttl)
+		assert.Nil(err)
+		assert.True(swapped)
+		buf, _ := other.Get(ctx, key)
+		assert.Equal(data, buf)
+
+		// update by the replica
+		newData := []byte(`{"a":2}`)
+		swapped, err = ts.CompareAndSwap(ctx, key, data, newData, ttl)
+		assert.Nil(err)
+		assert.True(swapped)
+		buf, _ = l1.Get(ctx, key)
+		assert.Equal(newData, buf)
+
+		// the l1 of other replica is stale
+		swapped, err = other.CompareAndSwap(ctx, key, data, []byte(`{"a":3}`), ttl)
+		assert.Nil(err)
+		assert.False(swapped)
+		buf, _ = otherL1.Get(ctx, key)
+		assert.Empty(buf, "stale data should be removed from l1")
+		buf, _ = other.Get(ctx, key)
+		assert.Equal(newData, buf)
+	})
+
+	t.Run("session", func(t *testing.T) {
+		assert := assert.New(t)
+		id := generateID()
+		s := &Session{
+			Store: ts,
+			ID:    id,
+		}
+		assert.Nil(s.Set(ctx, "a", 1))
+		assert.Nil(s.Commit(ctx, ttl))
+
+		s1 := &Session{
+			Store:         ts,
+			ID:            id,
+			ConflictRetry: 1,
+		}
+		s2 := &Session{
+			Store:         other,
+			ID:            id,
+			ConflictRetry: 1,
+		}
+		assert.Nil(s1.Set(ctx, "b", 2))
+		assert.Nil(s2.Set(ctx, "c", 3))
+		assert.Nil(s1.Commit(ctx, ttl))
+		assert.Nil(s2.Commit(ctx, ttl))
+
+		s = &Session{
+			Store: ts,
+			ID:    id,
+		}
+		assert.Equal(1, s.GetInt("a"))
+		assert.Equal(2, s.GetInt("b"))
+		assert.Equal(0, s.GetInt("c"), "the l1 of replica may be stale within the l1 ttl")
+		s = &Session{
+			Store: other,
+			ID:    id,
+		}
+		assert.Equal(1, s.GetInt("a"))
+		assert.Equal(2, s.GetInt("b"))
+		assert.Equal(3, s.GetInt("c"))
+	})
+}
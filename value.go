@@ -0,0 +1,93 @@
+// MIT License
+
+// Copyright (c) 2020 Tree Xie
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+var (
+	// ErrKeyNotFound the key of session is not found
+	ErrKeyNotFound = createError("session key not found")
+	// ErrTypeMismatch the type of session value is mismatch
+	ErrTypeMismatch = createError("session value type mismatch")
+)
+
+// convertValue converts the value to result(pointer) by json,
+// such as json.Number to int64, map to struct
+func convertValue(key string, value, result interface{}) error {
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("%w: %s, %v", ErrTypeMismatch, key, err)
+	}
+	err = json.Unmarshal(buf, result)
+	if err != nil {
+		return fmt.Errorf("%w: %s, %v", ErrTypeMismatch, key, err)
+	}
+	return nil
+}
+
+func (s *Session) lookupValue(ctx context.Context, key string) (interface{}, error) {
+	value, ok, err := s.Lookup(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	return value, nil
+}
+
+// GetAs get the value of session as type T, it returns ErrKeyNotFound
+// if the key is not exists and ErrTypeMismatch if the value can't be converted to T
+func GetAs[T any](ctx context.Context, s *Session, key string) (result T, err error) {
+	value, err := s.lookupValue(ctx, key)
+	if err != nil {
+		return
+	}
+	v, ok := value.(T)
+	if ok {
+		return v, nil
+	}
+	// 类型不一致时（如从store中读取的数据），尝试转换
+	err = convertValue(key, value, &result)
+	return
+}
+
+// SetAs set the value of session with type T
+func SetAs[T any](ctx context.Context, s *Session, key string, value T) error {
+	return s.Set(ctx, key, value)
+}
+
+// Bind decodes the value of session to v(pointer of struct, map and so on),
+// it returns ErrKeyNotFound if the key is not exists and ErrTypeMismatch
+// if the value can't be decoded to v
+func (s *Session) Bind(ctx context.Context, key string, v interface{}) error {
+	value, err := s.lookupValue(ctx, key)
+	if err != nil {
+		return err
+	}
+	return convertValue(key, value, v)
+}
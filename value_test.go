@@ -0,0 +1,124 @@
+// MIT License
+
+// Copyright (c) 2020 Tree Xie
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testAccount struct {
+	ID    int64    `json:"id"`
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+}
+
+func TestGetSetAs(t *testing.T) {
+	assert := assert.New(t)
+	store, err := NewMemoryStore(10)
+	ctx := context.Background()
+	assert.Nil(err, "new memory store fail")
+	id := generateID()
+	s := &Session{
+		Store: store,
+		ID:    id,
+	}
+	account := testAccount{
+		ID:    9007199254740993,
+		Name:  "tree.xie",
+		Roles: []string{"admin"},
+	}
+	err = SetAs(ctx, s, "account", account)
+	assert.Nil(err)
+	err = SetAs(ctx, s, "count", 1)
+	assert.Nil(err)
+
+	result, err := GetAs[testAccount](ctx, s, "account")
+	assert.Nil(err)
+	assert.Equal(account, result)
+
+	err = s.Commit(ctx, time.Minute)
+	assert.Nil(err)
+
+	// 从store中读取，数据为map
+	s = &Session{
+		Store: store,
+		ID:    id,
+	}
+	result, err = GetAs[testAccount](ctx, s, "account")
+	assert.Nil(err)
+	assert.Equal(account, result)
+
+	count, err := GetAs[int](ctx, s, "count")
+	assert.Nil(err)
+	assert.Equal(1, count)
+
+	_, err = GetAs[int](ctx, s, "not-exists")
+	assert.True(errors.Is(err, ErrKeyNotFound))
+
+	_, err = GetAs[string](ctx, s, "count")
+	assert.True(errors.Is(err, ErrTypeMismatch))
+
+	_, err = GetAs[int](ctx, s, "account")
+	assert.True(errors.Is(err, ErrTypeMismatch))
+}
+
+func TestBind(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	s := &Session{}
+	err := s.SetMap(ctx, map[string]interface{}{
+		"account": map[string]interface{}{
+			"id":    1,
+			"name":  "tree.xie",
+			"roles": []interface{}{"admin"},
+		},
+		"invalid": map[string]interface{}{
+			"id": "abc",
+		},
+		"func": func() {},
+	})
+	assert.Nil(err)
+
+	account := testAccount{}
+	err = s.Bind(ctx, "account", &account)
+	assert.Nil(err)
+	assert.Equal(testAccount{
+		ID:    1,
+		Name:  "tree.xie",
+		Roles: []string{"admin"},
+	}, account)
+
+	err = s.Bind(ctx, "not-exists", &account)
+	assert.True(errors.Is(err, ErrKeyNotFound))
+
+	err = s.Bind(ctx, "invalid", &account)
+	assert.True(errors.Is(err, ErrTypeMismatch))
+
+	err = s.Bind(ctx, "func", &account)
+	assert.True(errors.Is(err, ErrTypeMismatch))
+}
@@ -0,0 +1,93 @@
+// MIT License
+
+// Copyright (c) 2020 Tree Xie
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package session
+
+import (
+	"bytes"
+	"context"
+	"time"
+)
+
+// transformFunc transforms the data of session, it's used by the store wrapper
+// which changes the stored data(such as encryption)
+type transformFunc func(data []byte) ([]byte, error)
+
+// touchStore extend the ttl of session for the store wrapper, if the store
+// doesn't implement Toucher, the raw data is set to store again with the new ttl
+func touchStore(ctx context.Context, store Store, key string, ttl time.Duration) error {
+	if toucher, ok := store.(Toucher); ok {
+		return toucher.Touch(ctx, key, ttl)
+	}
+	buf, err := store.Get(ctx, key)
+	if err != nil || len(buf) == 0 {
+		return err
+	}
+	return store.Set(ctx, key, buf, ttl)
+}
+
+// ttlStore get the remaining ttl of session for the store wrapper,
+// it returns 0 if the store doesn't implement Toucher
+func ttlStore(ctx context.Context, store Store, key string) (time.Duration, error) {
+	if toucher, ok := store.(Toucher); ok {
+		return toucher.TTL(ctx, key)
+	}
+	return 0, nil
+}
+
+// compareAndSwapStore compare and swap for the store wrapper, the current data of store
+// is decoded for comparing with old, and the current raw data is used for the compare
+// and swap of store. If the store doesn't implement Swapper, the data is set to store without comparing.
+func compareAndSwapStore(ctx context.Context, store Store, key string, old, data []byte, ttl time.Duration, decode, encode transformFunc) (bool, error) {
+	swapper, ok := store.(Swapper)
+	if !ok {
+		buf, err := encode(data)
+		if err != nil {
+			return false, err
+		}
+		err = store.Set(ctx, key, buf, ttl)
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	current, err := store.Get(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	var buf []byte
+	if len(current) != 0 {
+		buf, err = decode(current)
+		if err != nil {
+			return false, err
+		}
+	} else {
+		current = nil
+	}
+	if !bytes.Equal(buf, old) {
+		return false, nil
+	}
+	buf, err = encode(data)
+	if err != nil {
+		return false, err
+	}
+	return swapper.CompareAndSwap(ctx, key, current, buf, ttl)
+}